@@ -0,0 +1,143 @@
+// Package balancer 利用注册中心里面的实例信息做负载均衡的 gRPC 策略
+// 导入这个包之后，就可以在 service config 里面按名字选择，例如
+// {"loadBalancingConfig": [{"micro_weighted_round_robin":{}}]}
+package balancer
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"web/micro"
+	"web/micro/registry"
+	"web/micro/rpc/loadbalance"
+)
+
+const (
+	// WeightedRoundRobin 按照实例的权重轮询
+	WeightedRoundRobin = "micro_weighted_round_robin"
+	// LeastActive 选择正在处理的请求最少的实例
+	LeastActive = "micro_least_active"
+	// ConsistentHash 按照请求元数据里面 HashKey 的值做一致性哈希
+	ConsistentHash = "micro_consistent_hash"
+	// Group 只选择 WithGroup 和 WithTags 指定的分组和标签的实例，再轮询
+	Group = "micro_group"
+)
+
+// pickerBuilders 按名字注册到 gRPC 的所有策略
+// 每个 gRPC 连接创建一个自己的 PickerBuilder，实例变化重新 Build 的时候负载均衡的状态还在
+var pickerBuilders = map[string]func() base.PickerBuilder{
+	WeightedRoundRobin: func() base.PickerBuilder {
+		return &lbPickerBuilder{balancer: loadbalance.NewWeightedRoundRobin()}
+	},
+	LeastActive: func() base.PickerBuilder {
+		return &lbPickerBuilder{balancer: loadbalance.NewLeastActive()}
+	},
+	ConsistentHash: func() base.PickerBuilder {
+		return &hashPickerBuilder{}
+	},
+	Group: func() base.PickerBuilder {
+		return &lbPickerBuilder{balancer: loadbalance.NewRoundRobin(), filter: matchGroup}
+	},
+}
+
+func init() {
+	for name, newPickerBuilder := range pickerBuilders {
+		balancer.Register(&builder{name: name, newPickerBuilder: newPickerBuilder})
+	}
+}
+
+type builder struct {
+	name             string
+	newPickerBuilder func() base.PickerBuilder
+}
+
+func (b *builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(b.name, b.newPickerBuilder(), base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+func (b *builder) Name() string {
+	return b.name
+}
+
+// readyInstances 取出可用的连接对应的实例，key 是实例地址
+func readyInstances(info base.PickerBuildInfo) ([]registry.ServiceInstance, map[string]balancer.SubConn) {
+	instances := make([]registry.ServiceInstance, 0, len(info.ReadySCs))
+	conns := make(map[string]balancer.SubConn, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		si, ok := micro.InstanceFromAddress(sci.Address)
+		if !ok {
+			// 不是通过注册中心解析出来的地址，只有地址没有其它信息
+			si = registry.ServiceInstance{Name: sci.Address.ServerName, Address: sci.Address.Addr}
+		}
+		instances = append(instances, si)
+		conns[si.Address] = sc
+	}
+	return instances, conns
+}
+
+// lbPickerBuilder 把 rpc/loadbalance 里面的策略用在 gRPC 上
+// 同一个 gRPC 连接的 picker 共用 balancer，比如 LeastActive 正在处理的请求数
+type lbPickerBuilder struct {
+	balancer loadbalance.Balancer
+	// 为 nil 的时候不过滤
+	filter func(info balancer.PickInfo, si registry.ServiceInstance) bool
+	// 上一次 Build 的实例地址，gRPC 保证 Build 不会并发调用
+	addrs map[string]balancer.SubConn
+}
+
+func (b *lbPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	instances, conns := readyInstances(info)
+	b.removeGone(conns)
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	return &lbPicker{
+		balancer:  b.balancer,
+		instances: instances,
+		conns:     conns,
+		filter:    b.filter,
+	}
+}
+
+// removeGone 不再可用的实例，清理 balancer 里面这个实例的状态，比如 LeastActive 的计数器
+func (b *lbPickerBuilder) removeGone(conns map[string]balancer.SubConn) {
+	if rm, ok := b.balancer.(loadbalance.Remover); ok {
+		for addr := range b.addrs {
+			if _, ok = conns[addr]; !ok {
+				rm.Remove(addr)
+			}
+		}
+	}
+	b.addrs = conns
+}
+
+type lbPicker struct {
+	balancer  loadbalance.Balancer
+	instances []registry.ServiceInstance
+	conns     map[string]balancer.SubConn
+	filter    func(info balancer.PickInfo, si registry.ServiceInstance) bool
+}
+
+func (p *lbPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	instances := p.instances
+	if p.filter != nil {
+		instances = make([]registry.ServiceInstance, 0, len(p.instances))
+		for _, si := range p.instances {
+			if p.filter(info, si) {
+				instances = append(instances, si)
+			}
+		}
+	}
+	si, done, err := p.balancer.Pick(info.Ctx, instances)
+	if err != nil {
+		// 返回 gRPC 的错误码，不然调用方拿到的是 Unknown
+		return balancer.PickResult{}, status.Error(codes.Unavailable, err.Error())
+	}
+	return balancer.PickResult{
+		SubConn: p.conns[si.Address],
+		Done: func(di balancer.DoneInfo) {
+			done(di.Err)
+		},
+	}, nil
+}
@@ -0,0 +1,201 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"testing"
+	"web/micro"
+	"web/micro/registry"
+	"web/micro/registry/memory"
+)
+
+func TestPickers(t *testing.T) {
+	instances := []registry.ServiceInstance{
+		{Name: "user-service", Address: "a", Weight: 3, Group: "gray", Tags: []string{"ssd"}},
+		{Name: "user-service", Address: "b", Weight: 1, Group: "gray"},
+		{Name: "user-service", Address: "c", Weight: 1},
+	}
+	testCases := []struct {
+		name   string
+		policy string
+		ctx    context.Context
+		// 选择的次数
+		n         int
+		wantCount map[string]int
+		wantCode  codes.Code
+	}{
+		{
+			name:      "weighted round robin",
+			policy:    WeightedRoundRobin,
+			ctx:       context.Background(),
+			n:         10,
+			wantCount: map[string]int{"a": 6, "b": 2, "c": 2},
+		},
+		{
+			name:      "consistent hash",
+			policy:    ConsistentHash,
+			ctx:       metadata.AppendToOutgoingContext(context.Background(), HashKey, "user-123"),
+			n:         10,
+			wantCount: map[string]int{"a": 10},
+		},
+		{
+			name:      "group",
+			policy:    Group,
+			ctx:       WithGroup(context.Background(), "gray"),
+			n:         4,
+			wantCount: map[string]int{"a": 2, "b": 2},
+		},
+		{
+			name:      "group and tags",
+			policy:    Group,
+			ctx:       WithTags(WithGroup(context.Background(), "gray"), "ssd"),
+			n:         4,
+			wantCount: map[string]int{"a": 4},
+		},
+		{
+			name:     "group not found",
+			policy:   Group,
+			ctx:      WithGroup(context.Background(), "prod"),
+			n:        1,
+			wantCode: codes.Unavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			picker := buildPicker(t, tc.policy, instances)
+			count := make(map[string]int, 3)
+			for i := 0; i < tc.n; i++ {
+				res, err := picker.Pick(balancer.PickInfo{Ctx: tc.ctx})
+				assert.Equal(t, tc.wantCode, status.Code(err))
+				if err != nil {
+					return
+				}
+				count[res.SubConn.(*fakeSubConn).addr]++
+				if res.Done != nil {
+					res.Done(balancer.DoneInfo{})
+				}
+			}
+			assert.Equal(t, tc.wantCount, count)
+		})
+	}
+}
+
+func TestLeastActivePicker(t *testing.T) {
+	picker := buildPicker(t, LeastActive, []registry.ServiceInstance{{Address: "a"}, {Address: "b"}})
+	first, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+	require.NoError(t, err)
+	second, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+	require.NoError(t, err)
+	// 第一个请求还没有结束，第二个请求选另外一个实例
+	assert.NotEqual(t, first.SubConn, second.SubConn)
+	first.Done(balancer.DoneInfo{})
+	third, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+	require.NoError(t, err)
+	assert.Equal(t, first.SubConn, third.SubConn)
+}
+
+// TestLeastActivePicker_Rebuild 实例变化重新 Build 之后，还记得正在处理的请求
+func TestLeastActivePicker_Rebuild(t *testing.T) {
+	pb := pickerBuilders[LeastActive]()
+	instances := []registry.ServiceInstance{{Address: "a"}, {Address: "b"}}
+	first, err := pb.Build(newBuildInfo(instances)).Pick(balancer.PickInfo{Ctx: context.Background()})
+	require.NoError(t, err)
+	second, err := pb.Build(newBuildInfo(instances)).Pick(balancer.PickInfo{Ctx: context.Background()})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SubConn.(*fakeSubConn).addr, second.SubConn.(*fakeSubConn).addr)
+}
+
+// TestLeastActivePicker_Remove 实例不再可用之后，它的计数器会被删掉
+func TestLeastActivePicker_Remove(t *testing.T) {
+	pb := pickerBuilders[LeastActive]()
+	pick := func(picker balancer.Picker) string {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		return res.SubConn.(*fakeSubConn).addr
+	}
+	a, b := registry.ServiceInstance{Address: "a"}, registry.ServiceInstance{Address: "b"}
+	picker := pb.Build(newBuildInfo([]registry.ServiceInstance{a}))
+	pick(picker)
+	pick(picker)
+	// a 还有两个请求没有结束，但是已经不可用了
+	pick(pb.Build(newBuildInfo([]registry.ServiceInstance{b})))
+	// a 重新上线，计数器是新的，比 b 少
+	assert.Equal(t, "a", pick(pb.Build(newBuildInfo([]registry.ServiceInstance{a, b}))))
+}
+
+// TestHashPicker_MaxWeight 权重很大的实例，虚拟节点的数量也有上限
+func TestHashPicker_MaxWeight(t *testing.T) {
+	picker := buildPicker(t, ConsistentHash, []registry.ServiceInstance{{Address: "a", Weight: math.MaxUint32}})
+	assert.Len(t, picker.(*hashPicker).ring, virtualNodes*maxWeight)
+}
+
+// TestServiceConfig 通过 service config 按名字选择策略
+func TestServiceConfig(t *testing.T) {
+	r := memory.NewRegistry()
+	defer r.Close()
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+		go func() {
+			_ = server.Serve(listener)
+		}()
+		defer server.Stop()
+		require.NoError(t, r.Register(context.Background(), registry.ServiceInstance{
+			Name:    "user-service",
+			Address: listener.Addr().String(),
+			Weight:  uint32(i + 1),
+		}))
+	}
+	builder, err := micro.NewRegistryBuilder(r)
+	require.NoError(t, err)
+
+	for _, policy := range []string{WeightedRoundRobin, LeastActive, ConsistentHash, Group} {
+		t.Run(policy, func(t *testing.T) {
+			cc, err := grpc.NewClient("registry:///user-service",
+				grpc.WithResolvers(builder),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{%q:{}}]}`, policy)))
+			require.NoError(t, err)
+			defer cc.Close()
+			resp, err := grpc_health_v1.NewHealthClient(cc).Check(context.Background(),
+				&grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true))
+			require.NoError(t, err)
+			assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+		})
+	}
+}
+
+func buildPicker(t *testing.T, policy string, instances []registry.ServiceInstance) balancer.Picker {
+	newPickerBuilder, ok := pickerBuilders[policy]
+	require.True(t, ok)
+	return newPickerBuilder().Build(newBuildInfo(instances))
+}
+
+func newBuildInfo(instances []registry.ServiceInstance) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo, len(instances))}
+	for _, si := range instances {
+		info.ReadySCs[&fakeSubConn{addr: si.Address}] = base.SubConnInfo{Address: micro.NewAddress(si)}
+	}
+	return info
+}
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"web/micro/registry"
+)
+
+type groupKey struct{}
+
+type groupFilter struct {
+	group string
+	tags  []string
+}
+
+// WithGroup 使用 Group 策略的时候，只调用这个分组的实例
+func WithGroup(ctx context.Context, group string) context.Context {
+	f := filterFromContext(ctx)
+	f.group = group
+	return context.WithValue(ctx, groupKey{}, f)
+}
+
+// WithTags 使用 Group 策略的时候，只调用带有全部这些标签的实例
+func WithTags(ctx context.Context, tags ...string) context.Context {
+	f := filterFromContext(ctx)
+	f.tags = append(append([]string(nil), f.tags...), tags...)
+	return context.WithValue(ctx, groupKey{}, f)
+}
+
+func filterFromContext(ctx context.Context) groupFilter {
+	f, _ := ctx.Value(groupKey{}).(groupFilter)
+	return f
+}
+
+// matchGroup 没有指定分组和标签的时候所有的实例都可以
+func matchGroup(info balancer.PickInfo, si registry.ServiceInstance) bool {
+	f := filterFromContext(info.Ctx)
+	if f.group != "" && f.group != si.Group {
+		return false
+	}
+	for _, tag := range f.tags {
+		if !hasTag(si.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// HashKey 一致性哈希使用的请求元数据的 key，例如
+// ctx = metadata.AppendToOutgoingContext(ctx, balancer.HashKey, userId)
+const HashKey = "x-micro-hash-key"
+
+const (
+	// virtualNodes 每个实例在哈希环上的虚拟节点数量，权重越大节点越多
+	virtualNodes = 100
+	// maxWeight 权重超过的按照它算，避免一个实例生成太多的虚拟节点
+	maxWeight = 100
+)
+
+type hashPickerBuilder struct{}
+
+func (b *hashPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	instances, conns := readyInstances(info)
+	p := &hashPicker{conns: make([]balancer.SubConn, 0, len(conns))}
+	for _, si := range instances {
+		p.conns = append(p.conns, conns[si.Address])
+		weight := int(si.Weight)
+		if weight <= 0 {
+			weight = 1
+		}
+		if weight > maxWeight {
+			weight = maxWeight
+		}
+		for i := 0; i < virtualNodes*weight; i++ {
+			p.ring = append(p.ring, hashNode{
+				hash: crc32.ChecksumIEEE([]byte(si.Address + "#" + strconv.Itoa(i))),
+				sc:   conns[si.Address],
+			})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+	return p
+}
+
+type hashNode struct {
+	hash uint32
+	sc   balancer.SubConn
+}
+
+// hashPicker 同一个 key 总是落到同一个实例上，实例变化的时候只有少部分 key 会迁移
+type hashPicker struct {
+	ring  []hashNode
+	conns []balancer.SubConn
+}
+
+func (p *hashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	md, _ := metadata.FromOutgoingContext(info.Ctx)
+	keys := md.Get(HashKey)
+	// 没有 key 的请求随便选一个
+	if len(keys) == 0 {
+		return balancer.PickResult{SubConn: p.conns[rand.Intn(len(p.conns))]}, nil
+	}
+	h := crc32.ChecksumIEEE([]byte(keys[0]))
+	idx := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= h
+	})
+	if idx == len(p.ring) {
+		idx = 0
+	}
+	return balancer.PickResult{SubConn: p.ring[idx].sc}, nil
+}
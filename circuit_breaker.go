@@ -0,0 +1,52 @@
+package micro
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"web/micro/circuitbreaker"
+)
+
+// CircuitBreakerInterceptor gRPC 的客户端拦截器，每个方法一个熔断器
+// 熔断的时候返回的错误码是 Unavailable，同时 errors.Is(err, circuitbreaker.ErrCircuitOpen) 成立
+// 默认只有 Unavailable、DeadlineExceeded 这类说明下游出问题的错误码才算失败，可以用 circuitbreaker.WithIsFailure 覆盖
+func CircuitBreakerInterceptor(opts ...circuitbreaker.Option) grpc.UnaryClientInterceptor {
+	opts = append([]circuitbreaker.Option{circuitbreaker.WithIsFailure(isGrpcBreakerFailure)}, opts...)
+	group := circuitbreaker.NewGroup(opts...)
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		done, err := group.Get(method).Allow()
+		if err != nil {
+			return circuitOpenError{}
+		}
+		err = invoker(ctx, method, req, reply, cc, callOpts...)
+		done(err)
+		return err
+	}
+}
+
+func isGrpcBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// circuitOpenError 既能被 status.Code 识别，也能用 errors.Is 判断
+type circuitOpenError struct{}
+
+func (circuitOpenError) Error() string {
+	return circuitbreaker.ErrCircuitOpen.Error()
+}
+
+func (circuitOpenError) Unwrap() error {
+	return circuitbreaker.ErrCircuitOpen
+}
+
+func (e circuitOpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
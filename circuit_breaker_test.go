@@ -0,0 +1,41 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+	"web/micro/circuitbreaker"
+)
+
+func TestCircuitBreakerInterceptor(t *testing.T) {
+	interceptor := CircuitBreakerInterceptor(
+		circuitbreaker.WithMinRequests(2),
+		circuitbreaker.WithOpenTimeout(time.Minute),
+	)
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if method == "/users.UserService/GetById" {
+			return status.Error(codes.Unavailable, "服务暂时不可用")
+		}
+		// 业务错误不算失败
+		return status.Error(codes.NotFound, "没有这个用户")
+	}
+
+	for i := 0; i < 3; i++ {
+		_ = interceptor(context.Background(), "/users.UserService/GetById", nil, nil, nil, invoker)
+		_ = interceptor(context.Background(), "/users.UserService/Find", nil, nil, nil, invoker)
+	}
+	err := interceptor(context.Background(), "/users.UserService/GetById", nil, nil, nil, invoker)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, errors.Is(err, circuitbreaker.ErrCircuitOpen))
+	err = interceptor(context.Background(), "/users.UserService/Find", nil, nil, nil, invoker)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	// GetById 只有前两次真正调用了
+	assert.Equal(t, 6, calls)
+}
@@ -0,0 +1,253 @@
+// Package circuitbreaker 熔断器，下游出问题的时候快速失败，不再把请求发过去
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器打开了，请求没有发出去
+var ErrCircuitOpen = errors.New("micro: 熔断器已打开")
+
+// State 熔断器的状态
+type State uint8
+
+const (
+	// StateClosed 正常放行，统计错误率和慢调用比例
+	StateClosed State = iota
+	// StateOpen 拒绝所有的请求，过了 OpenTimeout 之后进入半开
+	StateOpen
+	// StateHalfOpen 放行少量的探测请求，全部成功就关闭，有一个失败就重新打开
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// DoneFunc 请求结束的时候调用，传入请求的结果
+type DoneFunc func(err error)
+
+// Breaker 熔断器，使用滑动窗口统计错误率和慢调用比例
+type Breaker struct {
+	name  string
+	mutex sync.Mutex
+	state State
+	// 每次状态变化都加一，之前状态放行的请求结束的时候不再影响现在的状态
+	generation uint64
+	window     *window
+	// 打开的时间
+	openedAt time.Time
+	// 半开的时候已经放行的探测请求和成功的探测请求
+	probes    int
+	successes int
+
+	windowSize       time.Duration
+	windowBuckets    int
+	minRequests      int64
+	errorRate        float64
+	slowThreshold    time.Duration
+	slowRate         float64
+	openTimeout      time.Duration
+	halfOpenRequests int
+	isFailure        func(err error) bool
+	onStateChange    []func(name string, from, to State)
+	now              func() time.Time
+}
+
+type Option func(b *Breaker)
+
+// NewBreaker 创建一个熔断器，name 会传给状态变化的回调
+// 默认 10 秒的窗口里面至少 20 个请求，错误率达到 50% 的时候打开，5 秒之后半开
+func NewBreaker(name string, opts ...Option) *Breaker {
+	b := &Breaker{
+		name:             name,
+		windowSize:       10 * time.Second,
+		windowBuckets:    10,
+		minRequests:      20,
+		errorRate:        0.5,
+		openTimeout:      5 * time.Second,
+		halfOpenRequests: 1,
+		isFailure: func(err error) bool {
+			return err != nil
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.window = newWindow(b.windowSize, b.windowBuckets)
+	return b
+}
+
+// WithWindow 统计窗口的长度，以及分成几个时间片
+func WithWindow(size time.Duration, buckets int) Option {
+	return func(b *Breaker) {
+		b.windowSize = size
+		b.windowBuckets = buckets
+	}
+}
+
+// WithMinRequests 窗口里面的请求数达到 n 之后才会打开
+func WithMinRequests(n int64) Option {
+	return func(b *Breaker) {
+		b.minRequests = n
+	}
+}
+
+// WithErrorRate 错误率达到 rate 的时候打开，rate 为 0 的时候不看错误率
+func WithErrorRate(rate float64) Option {
+	return func(b *Breaker) {
+		b.errorRate = rate
+	}
+}
+
+// WithSlowCall 耗时超过 threshold 的是慢调用，慢调用的比例达到 rate 的时候打开
+func WithSlowCall(threshold time.Duration, rate float64) Option {
+	return func(b *Breaker) {
+		b.slowThreshold = threshold
+		b.slowRate = rate
+	}
+}
+
+// WithOpenTimeout 打开之后过多久进入半开
+func WithOpenTimeout(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.openTimeout = d
+	}
+}
+
+// WithHalfOpenRequests 半开的时候放行几个探测请求，都成功了才关闭
+func WithHalfOpenRequests(n int) Option {
+	return func(b *Breaker) {
+		if n > 0 {
+			b.halfOpenRequests = n
+		}
+	}
+}
+
+// WithIsFailure 判断请求的结果算不算失败，默认 err 不为 nil 就是失败
+func WithIsFailure(fn func(err error) bool) Option {
+	return func(b *Breaker) {
+		b.isFailure = fn
+	}
+}
+
+// WithOnStateChange 状态变化的时候调用，调用的时候持有熔断器的锁，不能在回调里面再调用熔断器
+func WithOnStateChange(fn func(name string, from, to State)) Option {
+	return func(b *Breaker) {
+		b.onStateChange = append(b.onStateChange, fn)
+	}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State 返回当前的状态，打开的时间已经超过 OpenTimeout 的时候返回半开
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refreshLocked(b.now())
+	return b.state
+}
+
+// Allow 判断请求能不能发出去，可以的话请求结束之后必须调用返回的 DoneFunc
+// 不可以的时候返回 ErrCircuitOpen
+func (b *Breaker) Allow() (DoneFunc, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	b.refreshLocked(now)
+	switch b.state {
+	case StateOpen:
+		return nil, ErrCircuitOpen
+	case StateHalfOpen:
+		if b.probes >= b.halfOpenRequests {
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+	}
+	generation := b.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			b.done(generation, now, err)
+		})
+	}, nil
+}
+
+func (b *Breaker) done(generation uint64, start time.Time, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	if generation != b.generation {
+		return
+	}
+	failure := b.isFailure(err)
+	slow := b.slowThreshold > 0 && now.Sub(start) >= b.slowThreshold
+	switch b.state {
+	case StateClosed:
+		b.window.add(now, failure, slow)
+		if b.shouldOpenLocked(now) {
+			b.setStateLocked(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failure || slow {
+			b.setStateLocked(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.setStateLocked(StateClosed, now)
+		}
+	}
+}
+
+func (b *Breaker) shouldOpenLocked(now time.Time) bool {
+	total, failures, slow := b.window.sum(now)
+	if total == 0 || total < b.minRequests {
+		return false
+	}
+	if b.errorRate > 0 && float64(failures)/float64(total) >= b.errorRate {
+		return true
+	}
+	return b.slowRate > 0 && float64(slow)/float64(total) >= b.slowRate
+}
+
+// refreshLocked 打开的时间到了就进入半开
+func (b *Breaker) refreshLocked(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.openTimeout {
+		b.setStateLocked(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) setStateLocked(state State, now time.Time) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.window.reset()
+	}
+	for _, fn := range b.onStateChange {
+		fn(b.name, from, state)
+	}
+}
@@ -0,0 +1,164 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("失败")
+
+// fakeClock 测试里面手动拨动的时间
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(clock *fakeClock, opts ...Option) *Breaker {
+	b := NewBreaker("test", append([]Option{
+		WithWindow(time.Second, 10),
+		WithMinRequests(4),
+		WithErrorRate(0.5),
+		WithOpenTimeout(time.Second),
+	}, opts...)...)
+	b.now = clock.Now
+	return b
+}
+
+// call 发起一次调用，耗时 cost
+func call(t *testing.T, b *Breaker, clock *fakeClock, cost time.Duration, err error) {
+	done, er := b.Allow()
+	require.NoError(t, er)
+	clock.now = clock.now.Add(cost)
+	done(err)
+}
+
+func TestBreaker(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option
+		// 在一个新的熔断器上依次调用
+		calls     []error
+		cost      time.Duration
+		wantState State
+	}{
+		{
+			name:      "below min requests",
+			calls:     []error{errTest, errTest, errTest},
+			wantState: StateClosed,
+		},
+		{
+			name:      "error rate",
+			calls:     []error{nil, errTest, nil, errTest},
+			wantState: StateOpen,
+		},
+		{
+			name:      "healthy",
+			calls:     []error{nil, errTest, nil, nil, nil},
+			wantState: StateClosed,
+		},
+		{
+			name:      "slow calls",
+			opts:      []Option{WithSlowCall(100*time.Millisecond, 0.5)},
+			calls:     []error{nil, nil, nil, nil},
+			cost:      200 * time.Millisecond,
+			wantState: StateOpen,
+		},
+		{
+			name: "not failure",
+			opts: []Option{WithIsFailure(func(err error) bool {
+				return false
+			})},
+			calls:     []error{errTest, errTest, errTest, errTest},
+			wantState: StateClosed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(100, 0)}
+			b := newTestBreaker(clock, tc.opts...)
+			for _, err := range tc.calls {
+				call(t, b, clock, tc.cost, err)
+			}
+			assert.Equal(t, tc.wantState, b.State())
+		})
+	}
+}
+
+func TestBreaker_Window(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	b := newTestBreaker(clock)
+	call(t, b, clock, 0, errTest)
+	call(t, b, clock, 0, errTest)
+	call(t, b, clock, 0, errTest)
+	// 之前的失败已经滑出窗口了
+	clock.now = clock.now.Add(2 * time.Second)
+	call(t, b, clock, 0, errTest)
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	testCases := []struct {
+		name      string
+		probe     error
+		wantState State
+	}{
+		{
+			name:      "probe succeeded",
+			wantState: StateClosed,
+		},
+		{
+			name:      "probe failed",
+			probe:     errTest,
+			wantState: StateOpen,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(100, 0)}
+			var changes []State
+			b := newTestBreaker(clock, WithOnStateChange(func(name string, from, to State) {
+				assert.Equal(t, "test", name)
+				changes = append(changes, to)
+			}))
+			for i := 0; i < 4; i++ {
+				call(t, b, clock, 0, errTest)
+			}
+			_, err := b.Allow()
+			assert.Equal(t, ErrCircuitOpen, err)
+
+			clock.now = clock.now.Add(time.Second)
+			assert.Equal(t, StateHalfOpen, b.State())
+			done, err := b.Allow()
+			require.NoError(t, err)
+			// 半开的时候只放行一个探测请求
+			_, err = b.Allow()
+			assert.Equal(t, ErrCircuitOpen, err)
+			done(tc.probe)
+			assert.Equal(t, tc.wantState, b.State())
+			assert.Equal(t, []State{StateOpen, StateHalfOpen, tc.wantState}, changes)
+		})
+	}
+}
+
+// TestBreaker_StaleDone 打开之前放行的请求，结束的时候不影响半开的探测
+func TestBreaker_StaleDone(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	b := newTestBreaker(clock)
+	stale, err := b.Allow()
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		call(t, b, clock, 0, errTest)
+	}
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	stale(errTest)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
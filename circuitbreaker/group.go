@@ -0,0 +1,48 @@
+package circuitbreaker
+
+import "sync"
+
+// Group 按照 key 管理一组熔断器，比如每个实例地址或者每个方法一个
+type Group struct {
+	mutex    sync.Mutex
+	opts     []Option
+	breakers map[string]*Breaker
+}
+
+// NewGroup 创建的熔断器都使用 opts，key 就是熔断器的名字
+func NewGroup(opts ...Option) *Group {
+	return &Group{
+		opts:     opts,
+		breakers: make(map[string]*Breaker, 8),
+	}
+}
+
+// Get 返回 key 对应的熔断器，没有的时候创建一个
+func (g *Group) Get(key string) *Breaker {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	b, ok := g.breakers[key]
+	if !ok {
+		b = NewBreaker(key, g.opts...)
+		g.breakers[key] = b
+	}
+	return b
+}
+
+// Remove 实例下线之后不再需要它的熔断器
+func (g *Group) Remove(key string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.breakers, key)
+}
+
+// RemoveIf 删除 key 满足 fn 的熔断器，比如实例下线之后删除这个实例上每个方法的熔断器
+func (g *Group) RemoveIf(fn func(key string) bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for key := range g.breakers {
+		if fn(key) {
+			delete(g.breakers, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package circuitbreaker
+
+import "time"
+
+// bucket 一个时间片里面的统计
+type bucket struct {
+	// 时间片的序号，过期的时间片要先清零
+	index    int64
+	total    int64
+	failures int64
+	slow     int64
+}
+
+// window 滑动窗口，把窗口分成若干个时间片，过期的时间片直接丢掉
+type window struct {
+	buckets []bucket
+	// 每个时间片的长度
+	width time.Duration
+}
+
+func newWindow(size time.Duration, n int) *window {
+	if n <= 0 {
+		n = 1
+	}
+	width := size / time.Duration(n)
+	if width <= 0 {
+		width = time.Millisecond
+	}
+	return &window{buckets: make([]bucket, n), width: width}
+}
+
+func (w *window) add(now time.Time, failure, slow bool) {
+	idx := now.UnixNano() / int64(w.width)
+	b := &w.buckets[idx%int64(len(w.buckets))]
+	if b.index != idx {
+		*b = bucket{index: idx}
+	}
+	b.total++
+	if failure {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+// sum 统计窗口里面还没有过期的时间片
+func (w *window) sum(now time.Time) (total, failures, slow int64) {
+	idx := now.UnixNano() / int64(w.width)
+	for _, b := range w.buckets {
+		if idx-b.index >= int64(len(w.buckets)) {
+			continue
+		}
+		total += b.total
+		failures += b.failures
+		slow += b.slow
+	}
+	return
+}
+
+func (w *window) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+	}
+}
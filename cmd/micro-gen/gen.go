@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const rpcImportPath = "web/micro/rpc"
+
+type method struct {
+	Name     string
+	ReqType  string
+	RespType string
+	// ReqElem 和 RespElem 是去掉 * 之后的类型
+	ReqElem  string
+	RespElem string
+}
+
+type data struct {
+	Package string
+	Type    string
+	// 客户端和服务端的 Name 都用它，接口自己的 Name 方法不参与，两边一定一致
+	ServiceName string
+	// 生成的代码在 rpc 包里面的时候为空，否则是 "rpc."
+	RPC     string
+	Imports []string
+	Methods []method
+}
+
+// generate 在 dir 里面找到 typeName 接口，生成代码
+func generate(dir, typeName, serviceName string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, iface, err := findInterface(fset, dir, typeName)
+	if err != nil {
+		return nil, err
+	}
+	d := &data{
+		Package:     file.Name.Name,
+		Type:        typeName,
+		ServiceName: serviceName,
+		RPC:         "rpc.",
+	}
+	if importPath(dir) == rpcImportPath {
+		d.RPC = ""
+	}
+
+	// 签名里面用到的包，需要在生成的文件里导入
+	used := map[string]bool{}
+	for _, field := range iface.Methods.List {
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%s 不支持嵌入其他接口", typeName)
+		}
+		name := field.Names[0].Name
+		// Name 方法不是远程方法
+		if name == "Name" && isNameMethod(fset, fn) {
+			continue
+		}
+		m, err := parseMethod(fset, name, fn)
+		if err != nil {
+			return nil, fmt.Errorf("方法 %s 不合法: %w", name, err)
+		}
+		d.Methods = append(d.Methods, m)
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					used[x.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	d.Imports = imports(file, used, d.RPC != "")
+
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+func findInterface(fset *token.FileSet, dir, typeName string) (*ast.File, *ast.InterfaceType, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range paths {
+		if strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, p, nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != typeName {
+					continue
+				}
+				iface, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					return nil, nil, fmt.Errorf("%s 不是接口", typeName)
+				}
+				return file, iface, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("在 %s 里找不到接口 %s", dir, typeName)
+}
+
+func isNameMethod(fset *token.FileSet, fn *ast.FuncType) bool {
+	return fn.Params.NumFields() == 0 && fn.Results.NumFields() == 1 &&
+		expr(fset, fn.Results.List[0].Type) == "string"
+}
+
+// parseMethod 方法必须是 func(context.Context, *Req) (*Resp, error) 的形式
+func parseMethod(fset *token.FileSet, name string, fn *ast.FuncType) (method, error) {
+	params := flatten(fn.Params)
+	results := flatten(fn.Results)
+	if len(params) != 2 || expr(fset, params[0]) != "context.Context" {
+		return method{}, errors.New("第一个参数必须是 context.Context，并且只能有两个参数")
+	}
+	reqStar, ok := params[1].(*ast.StarExpr)
+	if !ok {
+		return method{}, errors.New("第二个参数必须是指针")
+	}
+	if len(results) != 2 || expr(fset, results[1]) != "error" {
+		return method{}, errors.New("必须返回两个值，并且第二个是 error")
+	}
+	respStar, ok := results[0].(*ast.StarExpr)
+	if !ok {
+		return method{}, errors.New("第一个返回值必须是指针")
+	}
+	return method{
+		Name:     name,
+		ReqType:  expr(fset, params[1]),
+		RespType: expr(fset, results[0]),
+		ReqElem:  expr(fset, reqStar.X),
+		RespElem: expr(fset, respStar.X),
+	}, nil
+}
+
+// flatten 把 (a, b *T) 这种写法展开成每个参数一个类型
+func flatten(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	res := make([]ast.Expr, 0, len(fields.List))
+	for _, f := range fields.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			res = append(res, f.Type)
+		}
+	}
+	return res
+}
+
+func expr(fset *token.FileSet, e ast.Expr) string {
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, fset, e)
+	return buf.String()
+}
+
+// imports 返回生成的文件需要导入的包，context 和 serialize 是一定要的
+func imports(file *ast.File, used map[string]bool, needRPC bool) []string {
+	set := map[string]bool{
+		strconv.Quote("context"):                    true,
+		strconv.Quote(rpcImportPath + "/serialize"): true,
+	}
+	if needRPC {
+		set[strconv.Quote(rpcImportPath)] = true
+	}
+	for _, spec := range file.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if !used[name] {
+			continue
+		}
+		if spec.Name != nil {
+			set[spec.Name.Name+" "+spec.Path.Value] = true
+		} else {
+			set[spec.Path.Value] = true
+		}
+	}
+	res := make([]string, 0, len(set))
+	for imp := range set {
+		res = append(res, imp)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// importPath 根据 go.mod 推断 dir 的导入路径，找不到 go.mod 的时候返回空字符串
+func importPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for cur := abs; ; cur = filepath.Dir(cur) {
+		if module := modulePath(filepath.Join(cur, "go.mod")); module != "" {
+			rel, err := filepath.Rel(cur, abs)
+			if err != nil {
+				return ""
+			}
+			if rel == "." {
+				return module
+			}
+			return module + "/" + filepath.ToSlash(rel)
+		}
+		if filepath.Dir(cur) == cur {
+			return ""
+		}
+	}
+}
+
+func modulePath(gomod string) string {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`)
+		}
+	}
+	return ""
+}
+
+// kebab UserService 转成 user-service
+func kebab(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				sb.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+var tpl = template.Must(template.New("micro-gen").Parse(`// Code generated by micro-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// {{.Type}}Client 不使用反射的 {{.Type}} 客户端
+type {{.Type}}Client struct {
+	p {{.RPC}}Proxy
+	s serialize.Serialize
+}
+
+func New{{.Type}}Client(p {{.RPC}}Proxy, s serialize.Serialize) *{{.Type}}Client {
+	return &{{.Type}}Client{p: p, s: s}
+}
+
+func (c *{{.Type}}Client) Name() string {
+	return "{{.ServiceName}}"
+}
+{{range .Methods}}
+func (c *{{$.Type}}Client) {{.Name}}(ctx context.Context, req {{.ReqType}}) ({{.RespType}}, error) {
+	resp := new({{.RespElem}})
+	err := {{$.RPC}}Call(ctx, c.p, c.s, c.Name(), "{{.Name}}", req, resp)
+	return resp, err
+}
+{{end}}
+// {{.Type}}Dispatcher 不使用反射的服务端，实现了 {{.RPC}}Dispatcher，通过 RegisterService 注册
+type {{.Type}}Dispatcher struct {
+	impl {{.Type}}
+}
+
+func New{{.Type}}Dispatcher(impl {{.Type}}) *{{.Type}}Dispatcher {
+	return &{{.Type}}Dispatcher{impl: impl}
+}
+
+func (d *{{.Type}}Dispatcher) Name() string {
+	return "{{.ServiceName}}"
+}
+
+func (d *{{.Type}}Dispatcher) Dispatch(ctx context.Context, methodName string, s serialize.Serialize, data []byte) ([]byte, error) {
+	switch methodName {
+{{- range .Methods}}
+	case "{{.Name}}":
+		req := new({{.ReqElem}})
+		if err := {{$.RPC}}DecodeRequest(s, data, req); err != nil {
+			return nil, err
+		}
+		resp, err := d.impl.{{.Name}}(ctx, req)
+		if resp == nil {
+			return nil, err
+		}
+		return {{$.RPC}}EncodeResponse(s, resp, err)
+{{- end}}
+	default:
+		return nil, {{.RPC}}ErrMethodNotFound(d.Name(), methodName)
+	}
+}
+`))
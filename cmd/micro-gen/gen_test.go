@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestGenerate 生成的代码和提交的 rpc/userservice_gen.go 保持一致
+func TestGenerate(t *testing.T) {
+	want, err := os.ReadFile("../../rpc/userservice_gen.go")
+	require.NoError(t, err)
+	got, err := generate("../../rpc", "UserService", "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+// TestGenerate_Name 接口自己有 Name 方法的时候，客户端和服务端也都用 -name 指定的服务名
+func TestGenerate_Name(t *testing.T) {
+	got, err := generate("../../rpc", "UserService", "custom-service")
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(got), `return "custom-service"`))
+	assert.NotContains(t, string(got), "d.impl.Name()")
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		typeName string
+	}{
+		{
+			name:     "no context",
+			typeName: "NoCtxService",
+		},
+		{
+			name:     "no error",
+			typeName: "NoErrService",
+		},
+		{
+			name:     "not interface",
+			typeName: "NotInterface",
+		},
+		{
+			name:     "not found",
+			typeName: "OrderService",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := generate("testdata/bad", tc.typeName, "bad")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKebab(t *testing.T) {
+	assert.Equal(t, "user-service", kebab("UserService"))
+	assert.Equal(t, "order", kebab("Order"))
+}
@@ -0,0 +1,46 @@
+// micro-gen 根据 Go 接口生成不使用反射的客户端和服务端代码，一般配合 go:generate 使用：
+//
+//	//go:generate go run web/micro/cmd/micro-gen -type UserService
+//
+// 生成的 XxxClient 直接构造请求调用 rpc.Proxy，XxxDispatcher 实现了 rpc.Dispatcher，
+// 和 rpc.Client.InitService、rpc.Server.RegisterService 的反射实现在协议上是兼容的
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "接口名，必填")
+	serviceName := flag.String("name", "", "服务名，默认把接口名转成 kebab-case，比如 UserService 对应 user-service")
+	output := flag.String("output", "", "输出的文件名，默认是 <接口名小写>_gen.go")
+	flag.Parse()
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *serviceName == "" {
+		*serviceName = kebab(*typeName)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*typeName) + "_gen.go"
+	}
+
+	dir := "."
+	if args := flag.Args(); len(args) > 0 {
+		dir = args[0]
+	}
+	src, err := generate(dir, *typeName, *serviceName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "micro-gen:", err)
+		os.Exit(1)
+	}
+	if err = os.WriteFile(filepath.Join(dir, *output), src, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "micro-gen:", err)
+		os.Exit(1)
+	}
+}
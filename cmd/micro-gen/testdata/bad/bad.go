@@ -0,0 +1,17 @@
+package bad
+
+import "context"
+
+type NoCtxService interface {
+	GetById(req *GetByIdReq) (*GetByIdResp, error)
+}
+
+type NoErrService interface {
+	GetById(ctx context.Context, req *GetByIdReq) *GetByIdResp
+}
+
+type NotInterface struct{}
+
+type GetByIdReq struct{}
+
+type GetByIdResp struct{}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"strings"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	rpcPackage     = protogen.GoImportPath("web/micro/rpc")
+	protoPackage   = protogen.GoImportPath("web/micro/rpc/serialize/proto")
+)
+
+// generateFile 生成 xxx_micro.pb.go，没有 service 的文件不生成
+func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	if len(file.Services) == 0 {
+		return nil
+	}
+	filename := file.GeneratedFilenamePrefix + "_micro.pb.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+	g.P("// Code generated by protoc-gen-micro. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+	return g
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	methods := unaryMethods(service)
+	nameConst := service.GoName + "Name"
+	clientName := service.GoName + "Client"
+	serverName := service.GoName + "Server"
+	stubName := lowerFirst(serverName) + "Stub"
+
+	g.P("// ", nameConst, " 服务名，客户端和服务端必须一致")
+	g.P("const ", nameConst, " = \"", service.Desc.FullName(), "\"")
+	g.P()
+
+	// 客户端
+	g.P("// ", clientName, " 的方法由 rpc.Client 的 InitService 赋值")
+	for _, method := range streamingMethods(service) {
+		g.P("// ", method.GoName, " 是流式方法，不会生成，使用 rpc.Client 的 NewStream 调用")
+	}
+	g.P("type ", clientName, " struct {")
+	for _, method := range methods {
+		g.P(method.GoName, " func", signature(g, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("func (c *", clientName, ") Name() string {")
+	g.P("return ", nameConst)
+	g.P("}")
+	g.P()
+	g.P("// New", clientName, " 创建客户端，默认使用 proto 序列化协议")
+	g.P("func New", clientName, "(addr string, opts ...", rpcPackage.Ident("ClientOption"), ") (*", clientName, ", error) {")
+	g.P("opts = append([]", rpcPackage.Ident("ClientOption"), "{", rpcPackage.Ident("ClientWithSerializer"), "(&", protoPackage.Ident("Serializer"), "{})}, opts...)")
+	g.P("c, err := ", rpcPackage.Ident("NewClient"), "(addr, opts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return Init", clientName, "(c)")
+	g.P("}")
+	g.P()
+	g.P("// Init", clientName, " 使用已有的 rpc.Client 创建客户端，序列化协议由 c 决定")
+	g.P("func Init", clientName, "(c *", rpcPackage.Ident("Client"), ") (*", clientName, ", error) {")
+	g.P("res := &", clientName, "{}")
+	g.P("if err := c.InitService(res); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return res, nil")
+	g.P("}")
+	g.P()
+
+	// 服务端
+	g.P("// ", serverName, " 由用户实现")
+	g.P("type ", serverName, " interface {")
+	for _, method := range methods {
+		g.P(method.GoName, signature(g, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("// Register", serverName, " 注册服务，同时注册 proto 序列化协议")
+	g.P("func Register", serverName, "(s *", rpcPackage.Ident("Server"), ", srv ", serverName, ") error {")
+	g.P("s.RegisterSerializer(&", protoPackage.Ident("Serializer"), "{})")
+	g.P("return s.RegisterService(&", stubName, "{", serverName, ": srv})")
+	g.P("}")
+	g.P()
+	g.P("// ", stubName, " 只暴露 ", serverName, " 的方法，再补上 Name")
+	g.P("type ", stubName, " struct {")
+	g.P(serverName)
+	g.P("}")
+	g.P()
+	g.P("func (s *", stubName, ") Name() string {")
+	g.P("return ", nameConst)
+	g.P("}")
+	g.P()
+}
+
+// unaryMethods 暂时只支持一个请求一个响应的方法，流式的方法在生成的代码里面留下注释
+func unaryMethods(service *protogen.Service) []*protogen.Method {
+	res := make([]*protogen.Method, 0, len(service.Methods))
+	for _, method := range service.Methods {
+		if !isStreaming(method) {
+			res = append(res, method)
+		}
+	}
+	return res
+}
+
+func streamingMethods(service *protogen.Service) []*protogen.Method {
+	var res []*protogen.Method
+	for _, method := range service.Methods {
+		if isStreaming(method) {
+			res = append(res, method)
+		}
+	}
+	return res
+}
+
+func isStreaming(method *protogen.Method) bool {
+	return method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer()
+}
+
+func signature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	return "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context")) +
+		", req *" + g.QualifiedGoIdent(method.Input.GoIdent) +
+		") (*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
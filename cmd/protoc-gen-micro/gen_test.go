@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/bufbuild/protocompile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gengo "google.golang.org/protobuf/cmd/protoc-gen-go/internal_gengo"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// 修改了生成逻辑之后，用 go test -update 更新 golden 文件
+var update = flag.Bool("update", false, "更新 golden 文件")
+
+func TestGenerate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		importPath string
+		proto      string
+		golden     string
+	}{
+		{
+			name:       "user",
+			importPath: "../../proto",
+			proto:      "user.proto",
+			golden:     "testdata/user_micro.pb.go.golden",
+		},
+		{
+			// 流式方法不生成，只留下注释
+			name:       "streaming",
+			importPath: "testdata",
+			proto:      "chat.proto",
+			golden:     "testdata/chat_micro.pb.go.golden",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compiler := protocompile.Compiler{
+				Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+					ImportPaths: []string{tc.importPath},
+				}),
+			}
+			files, err := compiler.Compile(context.Background(), tc.proto)
+			require.NoError(t, err)
+
+			req := &pluginpb.CodeGeneratorRequest{
+				FileToGenerate: []string{tc.proto},
+				ProtoFile:      []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(files[0])},
+			}
+			plugin, err := protogen.Options{}.New(req)
+			require.NoError(t, err)
+			for _, f := range plugin.Files {
+				if f.Generate {
+					generateFile(plugin, f)
+					// protoc-gen-go 生成的消息，编译检查的时候需要
+					gengo.GenerateFile(plugin, f)
+				}
+			}
+			resp := plugin.Response()
+			require.Nil(t, resp.Error)
+			require.Len(t, resp.File, 2)
+			got := resp.File[0].GetContent()
+			compileCheck(t, resp.File)
+
+			if *update {
+				require.NoError(t, os.WriteFile(tc.golden, []byte(got), 0644))
+			}
+			want, err := os.ReadFile(filepath.Clean(tc.golden))
+			require.NoError(t, err)
+			assert.Equal(t, string(want), got)
+		})
+	}
+}
+
+// compileCheck 生成的代码要能和 protoc-gen-go 生成的代码一起编译通过
+// 目录放在 testdata 下面，go build ./... 不会编译它
+func compileCheck(t *testing.T, files []*pluginpb.CodeGeneratorResponse_File) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("没有找到 go 命令")
+	}
+	dir, err := os.MkdirTemp("testdata", "build")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, f := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, filepath.Base(f.GetName())), []byte(f.GetContent()), 0644))
+	}
+	out, err := exec.Command(goBin, "vet", "./"+dir).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
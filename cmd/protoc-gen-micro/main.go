@@ -0,0 +1,21 @@
+// protoc-gen-micro 根据 proto 文件里的 service 生成 rpc 包使用的客户端和服务端代码
+// 需要和 protoc-gen-go 一起使用：
+//
+//	protoc --go_out=. --micro_out=. user.proto
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		for _, f := range gen.Files {
+			if !f.Generate {
+				continue
+			}
+			generateFile(gen, f)
+		}
+		return nil
+	})
+}
@@ -2,82 +2,220 @@ package micro
 
 import (
 	"context"
+	"fmt"
+	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"reflect"
 	"time"
 	"web/micro/registry"
 )
 
+const (
+	// 订阅断开或者失败之后重新订阅的等待时间，每失败一次翻倍
+	minResubscribeBackoff = time.Millisecond * 100
+	maxResubscribeBackoff = time.Second * 10
+)
+
 type grpcResolverBuilder struct {
-	r registry.Registry
+	r       registry.Registry
+	scheme  string
+	timeout time.Duration
+	// 为 0 的时候每个事件都马上重新获取
+	debounce      time.Duration
+	serviceConfig string
+}
+
+type ResolverOption func(b *grpcResolverBuilder)
+
+// ResolverWithTimeout 设置从注册中心获取实例的超时时间，默认 3 秒
+func ResolverWithTimeout(timeout time.Duration) ResolverOption {
+	return func(b *grpcResolverBuilder) {
+		b.timeout = timeout
+	}
+}
+
+// ResolverWithScheme 设置 scheme，默认是 registry，也就是 registry:///服务名
+func ResolverWithScheme(scheme string) ResolverOption {
+	return func(b *grpcResolverBuilder) {
+		b.scheme = scheme
+	}
 }
 
-func NewRegistryBuilder(r registry.Registry) (resolver.Builder, error) {
-	return &grpcResolverBuilder{
-		r: r,
-	}, nil
+// ResolverWithDebounce 收到事件之后等待 debounce 再重新获取，期间的事件合并成一次
+// 大量实例同时上下线的时候，可以避免频繁地更新
+func ResolverWithDebounce(debounce time.Duration) ResolverOption {
+	return func(b *grpcResolverBuilder) {
+		b.debounce = debounce
+	}
+}
 
+// ResolverWithServiceConfig 设置 gRPC 的 service config，例如
+// {"loadBalancingConfig": [{"round_robin":{}}]}
+func ResolverWithServiceConfig(json string) ResolverOption {
+	return func(b *grpcResolverBuilder) {
+		b.serviceConfig = json
+	}
+}
+
+func NewRegistryBuilder(r registry.Registry, opts ...ResolverOption) (resolver.Builder, error) {
+	res := &grpcResolverBuilder{
+		r:       r,
+		scheme:  "registry",
+		timeout: time.Second * 3,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
 }
 
 func (b *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	r := &grpcResolver{
-		cc:     cc,
-		r:      b.r,
-		target: target,
+		cc:         cc,
+		r:          b.r,
+		target:     target,
+		timeout:    b.timeout,
+		debounce:   b.debounce,
+		resolveNow: make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	if b.serviceConfig != "" {
+		r.serviceConfig = cc.ParseServiceConfig(b.serviceConfig)
+		if r.serviceConfig.Err != nil {
+			cancel()
+			return nil, r.serviceConfig.Err
+		}
+	}
+	// 先订阅再获取，避免中间的变更被漏掉
+	events, err := b.r.Subscribe(target.Endpoint())
+	if err != nil {
+		// 订阅失败了 watch 会重新订阅，在这之前还能通过 ResolveNow 获取
+		cc.ReportError(err)
 	}
 	r.resolve()
-	go r.watch()
+	go r.watch(events)
 	return r, nil
 }
 
 func (b *grpcResolverBuilder) Scheme() string {
-	return "registry"
+	return b.scheme
 }
 
 type grpcResolver struct {
-	r       registry.Registry
-	cc      resolver.ClientConn
-	target  resolver.Target
-	timeout time.Duration
-	close   chan struct{}
+	r             registry.Registry
+	cc            resolver.ClientConn
+	target        resolver.Target
+	timeout       time.Duration
+	debounce      time.Duration
+	serviceConfig *serviceconfig.ParseResult
+	// ResolveNow 只是通知 watch 去获取，不能阻塞 gRPC
+	resolveNow chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
 func (g *grpcResolver) ResolveNow(options resolver.ResolveNowOptions) {
-	g.resolve()
+	select {
+	case g.resolveNow <- struct{}{}:
+	default:
+	}
 }
 
-// watch 接收注册中心传来的通知
-func (g *grpcResolver) watch() {
-	events, err := g.r.Subscribe(g.target.Endpoint())
-	if err != nil {
-		g.cc.ReportError(err)
-		return
+// watch 一直接收注册中心传来的通知，直到 Close
+// 所有的 resolve 都在这里串行执行
+// events 为 nil 或者被关闭的时候，按照退避时间重新订阅
+func (g *grpcResolver) watch(events <-chan registry.Event) {
+	var (
+		timer   *time.Timer
+		timerCh <-chan time.Time
+		retry   *time.Timer
+		retryCh <-chan time.Time
+		backoff = minResubscribeBackoff
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
+	resubscribe := func() {
+		retry = time.NewTimer(backoff)
+		retryCh = retry.C
 	}
-	select {
-	case <-events:
-		g.resolve()
-	case <-g.close:
-		return
+	if events == nil {
+		resubscribe()
+	}
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				// 订阅断开了，等待的时候还能响应 ResolveNow
+				events = nil
+				backoff = minResubscribeBackoff
+				resubscribe()
+				continue
+			}
+			if g.debounce <= 0 {
+				g.resolve()
+				continue
+			}
+			// 第一个事件开始计时，计时结束之前的事件都合并
+			if timerCh == nil {
+				timer = time.NewTimer(g.debounce)
+				timerCh = timer.C
+			}
+		case <-timerCh:
+			timerCh = nil
+			g.resolve()
+		case <-retryCh:
+			retryCh = nil
+			var err error
+			events, err = g.r.Subscribe(g.target.Endpoint())
+			if err != nil {
+				g.cc.ReportError(err)
+				backoff *= 2
+				if backoff > maxResubscribeBackoff {
+					backoff = maxResubscribeBackoff
+				}
+				resubscribe()
+				continue
+			}
+			// 断开期间的变更可能已经丢了，重新获取全部实例
+			g.resolve()
+		case <-g.resolveNow:
+			g.resolve()
+		case <-g.ctx.Done():
+			return
+		}
 	}
 }
 
 func (g *grpcResolver) resolve() {
-	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	ctx, cancel := context.WithTimeout(g.ctx, g.timeout)
 	defer cancel()
 	instances, err := g.r.ListServices(ctx, g.target.Endpoint())
 	if err != nil {
 		g.cc.ReportError(err)
 		return
 	}
+	// 一个实例都没有的时候，多半是注册中心出问题了，保留原来的节点
+	if len(instances) == 0 {
+		g.cc.ReportError(fmt.Errorf("micro: 服务 %s 没有可用的实例", g.target.Endpoint()))
+		return
+	}
 	address := make([]resolver.Address, 0, len(instances))
 	for _, instance := range instances {
-		address = append(address, resolver.Address{
-			Addr:       instance.Address,
-			ServerName: instance.Name,
-		})
+		address = append(address, NewAddress(instance))
 	}
 	// 更改可用的节点
 	err = g.cc.UpdateState(resolver.State{
-		Addresses: address,
+		Addresses:     address,
+		ServiceConfig: g.serviceConfig,
 	})
 	if err != nil {
 		g.cc.ReportError(err)
@@ -85,7 +223,39 @@ func (g *grpcResolver) resolve() {
 	}
 }
 
+// instanceKey resolver.Address.Attributes 里面保存实例信息的 key
+type instanceKey struct{}
+
+// instanceAttr 实例里面有切片和 map，不能直接比较，gRPC 比较地址的时候会调用 Equal
+type instanceAttr struct {
+	si registry.ServiceInstance
+}
+
+func (a instanceAttr) Equal(o any) bool {
+	oa, ok := o.(instanceAttr)
+	return ok && reflect.DeepEqual(a.si, oa.si)
+}
+
+// NewAddress 把实例转换成 gRPC 的地址，实例信息放在 Attributes 里面
+func NewAddress(si registry.ServiceInstance) resolver.Address {
+	return resolver.Address{
+		Addr:       si.Address,
+		ServerName: si.Name,
+		Attributes: attributes.New(instanceKey{}, instanceAttr{si: si}),
+	}
+}
+
+// InstanceFromAddress 取出注册中心里面的实例信息
+// gRPC 的负载均衡可以据此使用权重、分组、标签等信息
+func InstanceFromAddress(addr resolver.Address) (registry.ServiceInstance, bool) {
+	if addr.Attributes == nil {
+		return registry.ServiceInstance{}, false
+	}
+	attr, ok := addr.Attributes.Value(instanceKey{}).(instanceAttr)
+	return attr.si, ok
+}
+
+// Close 可以多次调用
 func (g *grpcResolver) Close() {
-	// close 发送一个关闭的信号给某个通道
-	close(g.close)
+	g.cancel()
 }
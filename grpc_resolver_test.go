@@ -0,0 +1,276 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/memory"
+)
+
+func TestGrpcResolver(t *testing.T) {
+	r := memory.NewRegistry()
+	defer r.Close()
+	ctx := context.Background()
+	a := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10}
+	b := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082"}
+	require.NoError(t, r.Register(ctx, a))
+
+	builder, err := NewRegistryBuilder(r, ResolverWithScheme("micro"), ResolverWithTimeout(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, "micro", builder.Scheme())
+	cc := &fakeClientConn{}
+	res, err := builder.Build(newTarget(t, "micro:///user-service"), cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer res.Close()
+	// Build 的时候就获取了一次
+	assert.Equal(t, []string{"127.0.0.1:8081"}, cc.addrs())
+	si, ok := InstanceFromAddress(cc.lastState().Addresses[0])
+	require.True(t, ok)
+	assert.Equal(t, a, si)
+
+	// 持续接收变更，而不是只处理一次
+	require.NoError(t, r.Register(ctx, b))
+	require.Eventually(t, func() bool {
+		return len(cc.addrs()) == 2
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, r.UnRegister(ctx, a))
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"127.0.0.1:8082"}, cc.addrs())
+	}, time.Second, 10*time.Millisecond)
+
+	// 实例都没有了，报告错误，保留原来的节点
+	require.NoError(t, r.UnRegister(ctx, b))
+	require.Eventually(t, func() bool {
+		return cc.lastErr() != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"127.0.0.1:8082"}, cc.addrs())
+
+	// 可以多次关闭
+	res.Close()
+	res.Close()
+}
+
+func TestGrpcResolver_Debounce(t *testing.T) {
+	r := &countingRegistry{Registry: memory.NewRegistry()}
+	defer r.Close()
+	ctx := context.Background()
+	require.NoError(t, r.Register(ctx, registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8080"}))
+	builder, err := NewRegistryBuilder(r, ResolverWithDebounce(50*time.Millisecond))
+	require.NoError(t, err)
+	cc := &fakeClientConn{}
+	res, err := builder.Build(newTarget(t, "registry:///user-service"), cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer res.Close()
+
+	for _, addr := range []string{"127.0.0.1:8081", "127.0.0.1:8082", "127.0.0.1:8083"} {
+		require.NoError(t, r.Register(ctx, registry.ServiceInstance{Name: "user-service", Address: addr}))
+	}
+	require.Eventually(t, func() bool {
+		return len(cc.addrs()) == 4
+	}, time.Second, 10*time.Millisecond)
+	// Build 一次，三个事件合并成一次
+	assert.Equal(t, 2, r.listCount())
+}
+
+// TestGrpcResolver_Resubscribe 订阅断开或者订阅失败之后重新订阅，并且拿到中间的变化
+func TestGrpcResolver_Resubscribe(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 第一次订阅失败，否则第一次订阅返回的 channel 由测试关闭
+		subscribeErr error
+	}{
+		{
+			name: "closed",
+		},
+		{
+			name:         "subscribe failed",
+			subscribeErr: errors.New("mock subscribe error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &resubscribeRegistry{Registry: memory.NewRegistry(), err: tc.subscribeErr}
+			defer r.Close()
+			ctx := context.Background()
+			require.NoError(t, r.Register(ctx, registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}))
+			builder, err := NewRegistryBuilder(r)
+			require.NoError(t, err)
+			cc := &fakeClientConn{}
+			res, err := builder.Build(newTarget(t, "registry:///user-service"), cc, resolver.BuildOptions{})
+			require.NoError(t, err)
+			defer res.Close()
+			assert.Equal(t, []string{"127.0.0.1:8081"}, cc.addrs())
+
+			if tc.subscribeErr == nil {
+				close(r.first)
+			}
+			// 订阅断开的时候上线的实例，收不到事件
+			require.NoError(t, r.Register(ctx, registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082"}))
+			require.Eventually(t, func() bool {
+				return len(cc.addrs()) == 2
+			}, time.Second, 10*time.Millisecond)
+			assert.Equal(t, 2, r.subscribeCount())
+		})
+	}
+}
+
+func TestGrpcResolver_ServiceConfig(t *testing.T) {
+	r := memory.NewRegistry()
+	defer r.Close()
+	require.NoError(t, r.Register(context.Background(), registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}))
+
+	testCases := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			config: `{"loadBalancingConfig": [{"round_robin":{}}]}`,
+		},
+		{
+			name:    "invalid",
+			config:  `{`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder, err := NewRegistryBuilder(r, ResolverWithServiceConfig(tc.config))
+			require.NoError(t, err)
+			cc := &fakeClientConn{}
+			res, err := builder.Build(newTarget(t, "registry:///user-service"), cc, resolver.BuildOptions{})
+			assert.Equal(t, tc.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			defer res.Close()
+			assert.Equal(t, tc.config, cc.lastState().ServiceConfig.Config.(fakeServiceConfig).json)
+		})
+	}
+}
+
+func newTarget(t *testing.T, target string) resolver.Target {
+	u, err := url.Parse(target)
+	require.NoError(t, err)
+	return resolver.Target{URL: *u}
+}
+
+// fakeClientConn 记录 resolver 推送的状态
+type fakeClientConn struct {
+	resolver.ClientConn
+	mutex  sync.Mutex
+	states []resolver.State
+	err    error
+}
+
+func (f *fakeClientConn) UpdateState(state resolver.State) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.states = append(f.states, state)
+	f.err = nil
+	return nil
+}
+
+func (f *fakeClientConn) ReportError(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.err = err
+}
+
+// ParseServiceConfig 真正的解析在 gRPC 内部，这里只区分合法和不合法
+func (f *fakeClientConn) ParseServiceConfig(json string) *serviceconfig.ParseResult {
+	if json == "{" {
+		return &serviceconfig.ParseResult{Err: errors.New("invalid service config")}
+	}
+	return &serviceconfig.ParseResult{Config: fakeServiceConfig{json: json}}
+}
+
+func (f *fakeClientConn) lastState() resolver.State {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.states) == 0 {
+		return resolver.State{}
+	}
+	return f.states[len(f.states)-1]
+}
+
+func (f *fakeClientConn) lastErr() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+func (f *fakeClientConn) addrs() []string {
+	state := f.lastState()
+	res := make([]string, 0, len(state.Addresses))
+	for _, addr := range state.Addresses {
+		res = append(res, addr.Addr)
+	}
+	sort.Strings(res)
+	return res
+}
+
+type fakeServiceConfig struct {
+	serviceconfig.Config
+	json string
+}
+
+// countingRegistry 记录 ListServices 调用的次数
+type countingRegistry struct {
+	registry.Registry
+	mutex sync.Mutex
+	count int
+}
+
+func (c *countingRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	c.mutex.Lock()
+	c.count++
+	c.mutex.Unlock()
+	return c.Registry.ListServices(ctx, serviceName)
+}
+
+func (c *countingRegistry) listCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.count
+}
+
+// resubscribeRegistry 第一次订阅返回 err，err 为 nil 的时候返回的 channel 由测试关闭
+type resubscribeRegistry struct {
+	registry.Registry
+	err        error
+	mutex      sync.Mutex
+	subscribes int
+	first      chan registry.Event
+}
+
+func (r *resubscribeRegistry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.subscribes++
+	if r.subscribes > 1 {
+		return r.Registry.Subscribe(serviceName)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.first = make(chan registry.Event)
+	return r.first, nil
+}
+
+func (r *resubscribeRegistry) subscribeCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.subscribes
+}
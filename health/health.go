@@ -0,0 +1,165 @@
+// Package health 记录服务的健康状态，micro.Server 和 rpc.Server 都通过它对外暴露健康检查
+package health
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnknownService 没有设置过状态的服务
+var ErrUnknownService = errors.New("micro: 未知的服务")
+
+// Status 健康状态，和 grpc.health.v1 的取值一致
+type Status uint8
+
+const (
+	StatusUnknown Status = iota
+	StatusServing
+	StatusNotServing
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Listener 状态变化的时候被调用，service 为空表示整个进程
+type Listener func(service string, status Status)
+
+// Server 按照服务名记录健康状态，空的服务名表示整个进程，默认是 SERVING
+type Server struct {
+	mutex     sync.Mutex
+	statuses  map[string]Status
+	listeners []Listener
+	// Shutdown 之后不再接受状态变化
+	shutdown bool
+	// 还没有通知的变化，按照变化的顺序排队
+	pending []notification
+	// 有没有协程正在通知
+	notifying bool
+}
+
+type notification struct {
+	listeners []Listener
+	service   string
+	status    Status
+}
+
+func NewServer() *Server {
+	return &Server{
+		statuses: map[string]Status{"": StatusServing},
+	}
+}
+
+// SetServingStatus 设置服务的状态，状态变化的时候通知所有的监听者
+// 没有别的协程正在通知的时候，在返回之前就通知完了
+func (s *Server) SetServingStatus(service string, status Status) {
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		return
+	}
+	s.setLocked(service, status)
+}
+
+// setLocked 进来的时候持有 mutex，出去的时候已经释放
+func (s *Server) setLocked(service string, status Status) {
+	old, ok := s.statuses[service]
+	s.statuses[service] = status
+	if ok && old == status {
+		s.mutex.Unlock()
+		return
+	}
+	s.pending = append(s.pending, notification{listeners: s.listeners, service: service, status: status})
+	s.notifyLocked()
+}
+
+// notifyLocked 进来的时候持有 mutex，出去的时候已经释放
+// 同一时间只有一个协程按照顺序调用监听者，调用的时候不持有锁
+// 监听者里面再设置状态也不会死锁，新的变化排在后面，由正在通知的协程接着通知
+func (s *Server) notifyLocked() {
+	if s.notifying {
+		s.mutex.Unlock()
+		return
+	}
+	s.notifying = true
+	for len(s.pending) > 0 {
+		n := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mutex.Unlock()
+		for _, l := range n.listeners {
+			l(n.service, n.status)
+		}
+		s.mutex.Lock()
+	}
+	s.notifying = false
+	s.mutex.Unlock()
+}
+
+// Check 返回服务的状态，没有设置过的服务返回 ErrUnknownService
+func (s *Server) Check(service string) (Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status, ok := s.statuses[service]
+	if !ok {
+		return StatusUnknown, ErrUnknownService
+	}
+	return status, nil
+}
+
+// List 返回所有服务的状态
+func (s *Server) List() map[string]Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := make(map[string]Status, len(s.statuses))
+	for k, v := range s.statuses {
+		res[k] = v
+	}
+	return res
+}
+
+// OnChange 添加监听者，添加的时候会先用当前所有的状态调用一次
+func (s *Server) OnChange(l Listener) {
+	s.mutex.Lock()
+	s.listeners = append(s.listeners, l)
+	for service, status := range s.statuses {
+		s.pending = append(s.pending, notification{listeners: []Listener{l}, service: service, status: status})
+	}
+	s.notifyLocked()
+}
+
+// Shutdown 把所有的服务都设置成 NOT_SERVING，之后的 SetServingStatus 都会被忽略
+// 退出之前调用，让调用方和注册中心尽早把这个实例摘掉
+func (s *Server) Shutdown() {
+	s.mutex.Lock()
+	s.shutdown = true
+	services := make([]string, 0, len(s.statuses))
+	for service := range s.statuses {
+		services = append(services, service)
+	}
+	s.mutex.Unlock()
+	for _, service := range services {
+		s.mutex.Lock()
+		s.setLocked(service, StatusNotServing)
+	}
+}
+
+// Resume 把所有的服务都设置成 SERVING，重新接受状态变化
+func (s *Server) Resume() {
+	s.mutex.Lock()
+	s.shutdown = false
+	services := make([]string, 0, len(s.statuses))
+	for service := range s.statuses {
+		services = append(services, service)
+	}
+	s.mutex.Unlock()
+	for _, service := range services {
+		s.SetServingStatus(service, StatusServing)
+	}
+}
@@ -0,0 +1,143 @@
+package health
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/memory"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	type change struct {
+		service string
+		status  Status
+	}
+	var changes []change
+	s.OnChange(func(service string, status Status) {
+		changes = append(changes, change{service: service, status: status})
+	})
+
+	s.SetServingStatus("user-service", StatusServing)
+	// 状态没有变化，不通知
+	s.SetServingStatus("user-service", StatusServing)
+	s.SetServingStatus("user-service", StatusNotServing)
+	st, err := s.Check("user-service")
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotServing, st)
+	_, err = s.Check("order-service")
+	assert.Equal(t, ErrUnknownService, err)
+
+	s.Shutdown()
+	// Shutdown 之后忽略状态变化
+	s.SetServingStatus("", StatusServing)
+	assert.Equal(t, map[string]Status{"": StatusNotServing, "user-service": StatusNotServing}, s.List())
+	s.Resume()
+	assert.Equal(t, map[string]Status{"": StatusServing, "user-service": StatusServing}, s.List())
+
+	assert.Equal(t, []change{
+		// 添加监听者的时候先通知当前的状态
+		{service: "", status: StatusServing},
+		{service: "user-service", status: StatusServing},
+		{service: "user-service", status: StatusNotServing},
+		{service: "", status: StatusNotServing},
+		{service: "", status: StatusServing},
+		{service: "user-service", status: StatusServing},
+	}, changes)
+}
+
+// TestServer_Reentrant 监听者里面再设置状态不会死锁，通知的顺序和变化的顺序一致
+func TestServer_Reentrant(t *testing.T) {
+	s := NewServer()
+	var services []string
+	s.OnChange(func(service string, status Status) {
+		services = append(services, service)
+		// 整个进程不健康的时候，把所有的服务都设置成不健康
+		if service == "" && status == StatusNotServing {
+			s.SetServingStatus("user-service", StatusNotServing)
+			s.SetServingStatus("order-service", StatusNotServing)
+		}
+	})
+	s.SetServingStatus("", StatusNotServing)
+	assert.Equal(t, []string{"", "", "user-service", "order-service"}, services)
+	assert.Equal(t, map[string]Status{
+		"":              StatusNotServing,
+		"user-service":  StatusNotServing,
+		"order-service": StatusNotServing,
+	}, s.List())
+}
+
+func TestRegistrar(t *testing.T) {
+	r := memory.NewRegistry()
+	defer r.Close()
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	s := NewServer()
+	g := NewRegistrar(r, si, time.Second)
+	s.OnChange(g.OnChange)
+
+	testCases := []struct {
+		name    string
+		service string
+		status  Status
+		wantIns []registry.ServiceInstance
+	}{
+		{
+			name:    "serving at start",
+			service: "",
+			status:  StatusServing,
+			wantIns: []registry.ServiceInstance{si},
+		},
+		{
+			name:    "not serving",
+			service: "",
+			status:  StatusNotServing,
+			wantIns: []registry.ServiceInstance{},
+		},
+		{
+			name:    "other service",
+			service: "order-service",
+			status:  StatusServing,
+			wantIns: []registry.ServiceInstance{},
+		},
+		{
+			name:    "serving again",
+			service: "",
+			status:  StatusServing,
+			wantIns: []registry.ServiceInstance{si},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.SetServingStatus(tc.service, tc.status)
+			require.NoError(t, g.Err())
+			ins, err := r.ListServices(context.Background(), si.Name)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantIns, ins)
+			assert.Equal(t, len(tc.wantIns) > 0, g.Registered())
+		})
+	}
+}
+
+// TestRegistrar_Refresh 注册中心有 TTL 的时候定时重新注册，注销之后不再注册
+func TestRegistrar_Refresh(t *testing.T) {
+	r := memory.NewRegistry(memory.RegistryWithTTL(50 * time.Millisecond))
+	defer r.Close()
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	s := NewServer()
+	s.OnChange(NewRegistrar(r, si, time.Second, RegistrarWithRefresh(10*time.Millisecond)).OnChange)
+
+	// 过了好几个 TTL 也还在
+	time.Sleep(200 * time.Millisecond)
+	ins, err := r.ListServices(context.Background(), si.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{si}, ins)
+
+	s.SetServingStatus("", StatusNotServing)
+	time.Sleep(100 * time.Millisecond)
+	ins, err = r.ListServices(context.Background(), si.Name)
+	require.NoError(t, err)
+	assert.Empty(t, ins)
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+	"web/micro/registry"
+)
+
+// Registrar 根据整个进程的健康状态注册和注销实例，不健康的实例不会被发现
+//
+//	s.OnChange(health.NewRegistrar(r, si, time.Second*3).OnChange)
+type Registrar struct {
+	r       registry.Registry
+	si      registry.ServiceInstance
+	timeout time.Duration
+	// 为 0 的时候只在状态变化的时候注册
+	refresh time.Duration
+
+	mutex      sync.Mutex
+	registered bool
+	// 最近一次注册或者注销的错误
+	err error
+	// 停止定时重新注册
+	stopRefresh context.CancelFunc
+}
+
+type RegistrarOption func(*Registrar)
+
+// RegistrarWithRefresh 注册之后每隔 interval 重新注册一次，注销之后停止
+// 用于需要注册方续约的注册中心，比如设置了 TTL 的 memory.Registry
+func RegistrarWithRefresh(interval time.Duration) RegistrarOption {
+	return func(g *Registrar) {
+		g.refresh = interval
+	}
+}
+
+func NewRegistrar(r registry.Registry, si registry.ServiceInstance, timeout time.Duration, opts ...RegistrarOption) *Registrar {
+	res := &Registrar{r: r, si: si, timeout: timeout}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// OnChange 实现了 Listener，只关心空的服务名
+// 变成 SERVING 的时候注册，其余的状态注销
+func (g *Registrar) OnChange(service string, status Status) {
+	if service != "" {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	switch {
+	case status == StatusServing && !g.registered:
+		g.err = g.r.Register(ctx, g.si)
+		g.registered = g.err == nil
+		if g.registered && g.refresh > 0 {
+			var refreshCtx context.Context
+			refreshCtx, g.stopRefresh = context.WithCancel(context.Background())
+			go g.refreshLoop(refreshCtx)
+		}
+	case status != StatusServing && g.registered:
+		g.err = g.r.UnRegister(ctx, g.si)
+		g.registered = g.err != nil
+		if !g.registered && g.stopRefresh != nil {
+			g.stopRefresh()
+			g.stopRefresh = nil
+		}
+	}
+}
+
+// refreshLoop 重新注册失败了也继续，下一次成功之前实例没有过期就行
+func (g *Registrar) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(g.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.mutex.Lock()
+			if ctx.Err() == nil {
+				regCtx, cancel := context.WithTimeout(ctx, g.timeout)
+				g.err = g.r.Register(regCtx, g.si)
+				cancel()
+			}
+			g.mutex.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Registered 实例现在是否在注册中心里面
+func (g *Registrar) Registered() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.registered
+}
+
+// Err 最近一次注册或者注销的错误
+func (g *Registrar) Err() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.err
+}
@@ -0,0 +1,102 @@
+package micro
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"web/micro/ratelimit"
+)
+
+// LimitScope 决定哪些请求共用一个限流器，fullMethod 的格式是 /包名.服务名/方法名
+// 返回空字符串的请求不限流
+type LimitScope func(ctx context.Context, fullMethod string) string
+
+// LimitGlobal 所有的请求共用一个限流器
+func LimitGlobal(ctx context.Context, fullMethod string) string {
+	return "*"
+}
+
+// LimitPerService 每个服务一个限流器
+func LimitPerService(ctx context.Context, fullMethod string) string {
+	service := strings.TrimPrefix(fullMethod, "/")
+	if idx := strings.LastIndex(service, "/"); idx >= 0 {
+		service = service[:idx]
+	}
+	return service
+}
+
+// LimitPerMethod 每个方法一个限流器
+func LimitPerMethod(ctx context.Context, fullMethod string) string {
+	return fullMethod
+}
+
+// LimitPerCaller 按照 metadata 里面 key 对应的调用方标识限流，没有带标识的请求不限流
+func LimitPerCaller(key string) LimitScope {
+	return func(ctx context.Context, fullMethod string) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if vals := md.Get(key); len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+}
+
+// RateLimitUnaryInterceptor 限流的拦截器，被拒绝的请求返回 ResourceExhausted
+// newLimiter 在第一次见到某个 key 的时候创建限流器，健康检查不限流
+// 限流器默认最多保留 10000 个，可以用 ratelimit.GroupWithMaxSize 调整
+func RateLimitUnaryInterceptor(scope LimitScope, newLimiter func(key string) ratelimit.Limiter, opts ...ratelimit.GroupOption) grpc.UnaryServerInterceptor {
+	group := ratelimit.NewGroup(newLimiter, opts...)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		done, err := allowGrpc(ctx, group, scope, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// RateLimitStreamInterceptor 流式方法的限流拦截器，整个流算一个请求
+func RateLimitStreamInterceptor(scope LimitScope, newLimiter func(key string) ratelimit.Limiter, opts ...ratelimit.GroupOption) grpc.StreamServerInterceptor {
+	group := ratelimit.NewGroup(newLimiter, opts...)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := allowGrpc(ss.Context(), group, scope, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		err = handler(srv, ss)
+		done(err)
+		return err
+	}
+}
+
+func allowGrpc(ctx context.Context, group *ratelimit.Group, scope LimitScope, fullMethod string) (ratelimit.DoneFunc, error) {
+	key := scope(ctx, fullMethod)
+	if key == "" || strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return func(err error) {}, nil
+	}
+	done, err := group.Get(key).Allow()
+	if err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "micro: 请求被限流 %s", key)
+	}
+	return func(err error) {
+		// 限流器不认识 gRPC 的错误，超时统一换成 context.DeadlineExceeded
+		if status.Code(err) == codes.DeadlineExceeded {
+			err = context.DeadlineExceeded
+		}
+		done(err)
+	}, nil
+}
+
+// ServerWithLimiter 添加一个限流器，同时作用于普通方法和流式方法，可以多次调用
+func ServerWithLimiter(scope LimitScope, newLimiter func(key string) ratelimit.Limiter, opts ...ratelimit.GroupOption) ServerOption {
+	return ServerWithGrpcOptions(
+		grpc.ChainUnaryInterceptor(RateLimitUnaryInterceptor(scope, newLimiter, opts...)),
+		grpc.ChainStreamInterceptor(RateLimitStreamInterceptor(scope, newLimiter, opts...)),
+	)
+}
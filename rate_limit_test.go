@@ -0,0 +1,54 @@
+package micro
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"web/micro/ratelimit"
+)
+
+func TestLimitScope(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("caller", "order-service"))
+	testCases := []struct {
+		name  string
+		scope LimitScope
+		ctx   context.Context
+		want  string
+	}{
+		{name: "global", scope: LimitGlobal, ctx: ctx, want: "*"},
+		{name: "service", scope: LimitPerService, ctx: ctx, want: "users.UserService"},
+		{name: "method", scope: LimitPerMethod, ctx: ctx, want: "/users.UserService/GetById"},
+		{name: "caller", scope: LimitPerCaller("caller"), ctx: ctx, want: "order-service"},
+		{name: "no caller", scope: LimitPerCaller("caller"), ctx: context.Background()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.scope(tc.ctx, "/users.UserService/GetById"))
+		})
+	}
+}
+
+func TestRateLimitUnaryInterceptor(t *testing.T) {
+	interceptor := RateLimitUnaryInterceptor(LimitPerService, func(key string) ratelimit.Limiter {
+		return ratelimit.NewTokenBucket(0.001, 1)
+	})
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+	call := func(method string) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	assert.NoError(t, call("/users.UserService/GetById"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("/users.UserService/List")))
+	assert.NoError(t, call("/orders.OrderService/GetById"))
+	// 健康检查不限流
+	assert.NoError(t, call("/grpc.health.v1.Health/Check"))
+	assert.NoError(t, call("/grpc.health.v1.Health/Check"))
+}
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+// Adaptive 自适应的并发限制，思路和 Netflix concurrency-limits 的 gradient 算法一样
+// 用最小的延迟作为没有排队时候的延迟，最近的延迟比它高说明开始排队了，就降低并发上限，反之提高
+// 请求超时的时候直接按比例降低并发上限
+type Adaptive struct {
+	mutex    sync.Mutex
+	limit    float64
+	inflight int
+	// 没有排队时候的延迟，每隔 probeInterval 重新测量，避免延迟整体上涨之后上限一直下降
+	minRTT     time.Duration
+	minRTTAt   time.Time
+	minLimit   float64
+	maxLimit   float64
+	smoothing  float64
+	backoff    float64
+	probeEvery time.Duration
+	isDrop     func(err error) bool
+	now        func() time.Time
+}
+
+type AdaptiveOption func(a *Adaptive)
+
+// NewAdaptive 默认初始的并发上限是 20，在 1 到 1000 之间调整
+func NewAdaptive(opts ...AdaptiveOption) *Adaptive {
+	res := &Adaptive{
+		limit:      20,
+		minLimit:   1,
+		maxLimit:   1000,
+		smoothing:  0.2,
+		backoff:    0.9,
+		probeEvery: time.Minute,
+		isDrop: func(err error) bool {
+			// 既认 context.DeadlineExceeded，也认服务端方法返回的 DeadlineExceeded 错误码
+			return status.Code(err) == codes.DeadlineExceeded
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func AdaptiveWithInitialLimit(limit int) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.limit = float64(limit)
+	}
+}
+
+// AdaptiveWithLimitRange 并发上限的调整范围
+func AdaptiveWithLimitRange(minLimit, maxLimit int) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.minLimit = float64(minLimit)
+		a.maxLimit = float64(maxLimit)
+	}
+}
+
+// AdaptiveWithSmoothing 每次调整的时候新的上限占的比重，取值 0 到 1，越大调整得越快
+func AdaptiveWithSmoothing(smoothing float64) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.smoothing = smoothing
+	}
+}
+
+// AdaptiveWithProbeInterval 隔多久重新测量一次最小延迟
+func AdaptiveWithProbeInterval(d time.Duration) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.probeEvery = d
+	}
+}
+
+// AdaptiveWithIsDrop 判断请求是不是因为过载失败了，默认是超时
+func AdaptiveWithIsDrop(fn func(err error) bool) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.isDrop = fn
+	}
+}
+
+// Limit 当前的并发上限
+func (a *Adaptive) Limit() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return int(a.limit)
+}
+
+func (a *Adaptive) Allow() (DoneFunc, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.inflight >= int(a.limit) {
+		return nil, ErrLimitExceeded
+	}
+	a.inflight++
+	start := a.now()
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			a.done(start, err)
+		})
+	}, nil
+}
+
+func (a *Adaptive) done(start time.Time, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	inflight := a.inflight
+	a.inflight--
+	now := a.now()
+	if a.isDrop(err) {
+		a.setLimitLocked(a.limit * a.backoff)
+		return
+	}
+	rtt := now.Sub(start)
+	if rtt <= 0 {
+		rtt = time.Nanosecond
+	}
+	if a.minRTT == 0 || rtt < a.minRTT || now.Sub(a.minRTTAt) >= a.probeEvery {
+		a.minRTT = rtt
+		a.minRTTAt = now
+	}
+	// 并发还远没有到上限的时候，延迟说明不了什么，不调整
+	if float64(inflight)*2 < a.limit {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, float64(a.minRTT)/float64(rtt)))
+	// 允许少量的排队，上限才能往上涨
+	newLimit := a.limit*gradient + math.Sqrt(a.limit)
+	a.setLimitLocked((1-a.smoothing)*a.limit + a.smoothing*newLimit)
+}
+
+func (a *Adaptive) setLimitLocked(limit float64) {
+	a.limit = math.Max(a.minLimit, math.Min(a.maxLimit, limit))
+}
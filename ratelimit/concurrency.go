@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Concurrency 固定的并发数
+type Concurrency struct {
+	max      int64
+	inflight int64
+}
+
+// NewConcurrency 同时最多处理 n 个请求
+func NewConcurrency(n int64) *Concurrency {
+	return &Concurrency{max: n}
+}
+
+func (c *Concurrency) Allow() (DoneFunc, error) {
+	if atomic.AddInt64(&c.inflight, 1) > c.max {
+		atomic.AddInt64(&c.inflight, -1)
+		return nil, ErrLimitExceeded
+	}
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			atomic.AddInt64(&c.inflight, -1)
+		})
+	}, nil
+}
@@ -0,0 +1,101 @@
+// Package ratelimit 限流器，rpc.Server 和 micro.Server 用它做准入控制
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// ErrLimitExceeded 请求被限流了
+var ErrLimitExceeded = errors.New("micro: 请求被限流")
+
+// DoneFunc 请求结束的时候调用，传入请求的结果
+type DoneFunc func(err error)
+
+// Limiter 限流器，放行的时候返回的 DoneFunc 必须在请求结束之后调用，拒绝的时候返回 ErrLimitExceeded
+// 限制速率的限流器不关心请求什么时候结束，限制并发的限流器要靠 DoneFunc 归还名额
+type Limiter interface {
+	Allow() (DoneFunc, error)
+}
+
+func noop(err error) {}
+
+// defaultGroupSize Group 默认最多保留的限流器数量
+// key 可能来自调用方，比如调用方标识，不限制的话调用方换着 key 请求就能让内存一直增长
+const defaultGroupSize = 10000
+
+// Group 按照 key 管理一组限流器，比如每个服务、每个方法或者每个调用方一个
+// 限流器超过 maxSize 个的时候淘汰最久没有用过的，被淘汰的 key 下次再来会重新创建
+type Group struct {
+	mutex      sync.Mutex
+	newLimiter func(key string) Limiter
+	maxSize    int
+	limiters   map[string]*list.Element
+	// 最近用过的在前面
+	lru *list.List
+}
+
+type groupEntry struct {
+	key     string
+	limiter Limiter
+}
+
+type GroupOption func(g *Group)
+
+// GroupWithMaxSize 最多保留 n 个限流器，默认是 10000，n <= 0 表示不限制
+func GroupWithMaxSize(n int) GroupOption {
+	return func(g *Group) {
+		g.maxSize = n
+	}
+}
+
+// NewGroup newLimiter 在第一次见到某个 key 的时候创建限流器
+func NewGroup(newLimiter func(key string) Limiter, opts ...GroupOption) *Group {
+	res := &Group{
+		newLimiter: newLimiter,
+		maxSize:    defaultGroupSize,
+		limiters:   make(map[string]*list.Element, 8),
+		lru:        list.New(),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// Get 返回 key 对应的限流器，没有的时候创建一个
+func (g *Group) Get(key string) Limiter {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if ele, ok := g.limiters[key]; ok {
+		g.lru.MoveToFront(ele)
+		return ele.Value.(*groupEntry).limiter
+	}
+	l := g.newLimiter(key)
+	g.limiters[key] = g.lru.PushFront(&groupEntry{key: key, limiter: l})
+	if g.maxSize > 0 && g.lru.Len() > g.maxSize {
+		// 已经放行的请求还拿着旧的限流器，结束的时候照常归还，不影响新的限流器
+		oldest := g.lru.Back()
+		g.lru.Remove(oldest)
+		delete(g.limiters, oldest.Value.(*groupEntry).key)
+	}
+	return l
+}
+
+// Remove 不再需要 key 对应的限流器，比如调用方已经下线了
+func (g *Group) Remove(key string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if ele, ok := g.limiters[key]; ok {
+		g.lru.Remove(ele)
+		delete(g.limiters, key)
+	}
+}
+
+// Len 当前保留的限流器数量
+func (g *Group) Len() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.lru.Len()
+}
@@ -0,0 +1,202 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+// fakeClock 测试里面手动拨动的时间
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	tb := NewTokenBucket(10, 2)
+	tb.now = clock.Now
+	tb.last = clock.now
+
+	testCases := []struct {
+		name    string
+		elapsed time.Duration
+		wantErr error
+	}{
+		{name: "burst 1"},
+		{name: "burst 2"},
+		{name: "empty", wantErr: ErrLimitExceeded},
+		{name: "not enough", elapsed: 50 * time.Millisecond, wantErr: ErrLimitExceeded},
+		{name: "refilled", elapsed: 50 * time.Millisecond},
+		// 空闲很久也只能攒 burst 个
+		{name: "idle 1", elapsed: time.Minute},
+		{name: "idle 2"},
+		{name: "idle 3", wantErr: ErrLimitExceeded},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock.now = clock.now.Add(tc.elapsed)
+			_, err := tb.Allow()
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	w := NewSlidingWindow(2, time.Second, 10)
+	w.now = clock.Now
+
+	testCases := []struct {
+		name    string
+		elapsed time.Duration
+		wantErr error
+	}{
+		{name: "first"},
+		{name: "second", elapsed: 500 * time.Millisecond},
+		{name: "full", elapsed: 400 * time.Millisecond, wantErr: ErrLimitExceeded},
+		// 第一个请求滑出了窗口
+		{name: "slid", elapsed: 200 * time.Millisecond},
+		{name: "full again", wantErr: ErrLimitExceeded},
+		{name: "all slid", elapsed: time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock.now = clock.now.Add(tc.elapsed)
+			_, err := w.Allow()
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	c := NewConcurrency(2)
+	done1, err := c.Allow()
+	require.NoError(t, err)
+	_, err = c.Allow()
+	require.NoError(t, err)
+	_, err = c.Allow()
+	assert.Equal(t, ErrLimitExceeded, err)
+	done1(nil)
+	// 重复调用不会多归还名额
+	done1(nil)
+	_, err = c.Allow()
+	require.NoError(t, err)
+	_, err = c.Allow()
+	assert.Equal(t, ErrLimitExceeded, err)
+}
+
+func TestAdaptive(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 每一轮把并发打满，每个请求的耗时
+		rtts      []time.Duration
+		err       error
+		wantLower bool
+	}{
+		{
+			name:      "steady latency",
+			rtts:      []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond},
+			wantLower: false,
+		},
+		{
+			name:      "queueing",
+			rtts:      []time.Duration{10 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond},
+			wantLower: true,
+		},
+		{
+			name:      "timeout",
+			rtts:      []time.Duration{10 * time.Millisecond},
+			err:       context.DeadlineExceeded,
+			wantLower: true,
+		},
+		{
+			name:      "timeout status",
+			rtts:      []time.Duration{10 * time.Millisecond},
+			err:       status.Error(codes.DeadlineExceeded, "超时"),
+			wantLower: true,
+		},
+		{
+			name:      "business error",
+			rtts:      []time.Duration{10 * time.Millisecond},
+			err:       status.Error(codes.NotFound, "没有这个用户"),
+			wantLower: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(100, 0)}
+			a := NewAdaptive(AdaptiveWithInitialLimit(10), AdaptiveWithSmoothing(1))
+			a.now = clock.Now
+			for _, rtt := range tc.rtts {
+				limit := a.Limit()
+				dones := make([]DoneFunc, 0, limit)
+				for i := 0; i < limit; i++ {
+					done, err := a.Allow()
+					require.NoError(t, err)
+					dones = append(dones, done)
+				}
+				// 并发打满了
+				_, err := a.Allow()
+				assert.Equal(t, ErrLimitExceeded, err)
+				clock.now = clock.now.Add(rtt)
+				for _, done := range dones {
+					done(tc.err)
+				}
+			}
+			assert.Equal(t, tc.wantLower, a.Limit() < 10)
+			if !tc.wantLower {
+				assert.Greater(t, a.Limit(), 10)
+			}
+		})
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := NewGroup(func(key string) Limiter {
+		return NewConcurrency(1)
+	})
+	_, err := g.Get("a").Allow()
+	require.NoError(t, err)
+	_, err = g.Get("a").Allow()
+	assert.Equal(t, ErrLimitExceeded, err)
+	_, err = g.Get("b").Allow()
+	assert.NoError(t, err)
+}
+
+func TestGroup_MaxSize(t *testing.T) {
+	created := 0
+	g := NewGroup(func(key string) Limiter {
+		created++
+		return NewConcurrency(1)
+	}, GroupWithMaxSize(2))
+	_, err := g.Get("a").Allow()
+	require.NoError(t, err)
+	g.Get("b")
+	// a 刚用过，淘汰的是 b
+	g.Get("a")
+	g.Get("c")
+	assert.Equal(t, 2, g.Len())
+	assert.Equal(t, 3, created)
+
+	// a 还在，名额没有归还
+	_, err = g.Get("a").Allow()
+	assert.Equal(t, ErrLimitExceeded, err)
+	// b 被淘汰了，重新创建
+	g.Get("b")
+	assert.Equal(t, 4, created)
+	assert.Equal(t, 2, g.Len())
+
+	g.Remove("b")
+	assert.Equal(t, 1, g.Len())
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindow 滑动窗口，任意一个窗口长度的时间里面最多放行 limit 个请求
+// 窗口分成若干个时间片，时间片越多越精确
+type SlidingWindow struct {
+	mutex sync.Mutex
+	limit int64
+	// 每个时间片的长度
+	width   time.Duration
+	indexes []int64
+	counts  []int64
+	now     func() time.Time
+}
+
+func NewSlidingWindow(limit int64, window time.Duration, buckets int) *SlidingWindow {
+	if buckets <= 0 {
+		buckets = 1
+	}
+	width := window / time.Duration(buckets)
+	if width <= 0 {
+		width = time.Millisecond
+	}
+	return &SlidingWindow{
+		limit:   limit,
+		width:   width,
+		indexes: make([]int64, buckets),
+		counts:  make([]int64, buckets),
+		now:     time.Now,
+	}
+}
+
+func (w *SlidingWindow) Allow() (DoneFunc, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	idx := w.now().UnixNano() / int64(w.width)
+	n := int64(len(w.counts))
+	var total int64
+	for i, index := range w.indexes {
+		if idx-index < n {
+			total += w.counts[i]
+		}
+	}
+	if total >= w.limit {
+		return nil, ErrLimitExceeded
+	}
+	pos := idx % n
+	if w.indexes[pos] != idx {
+		w.indexes[pos] = idx
+		w.counts[pos] = 0
+	}
+	w.counts[pos]++
+	return noop, nil
+}
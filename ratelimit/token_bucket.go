@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶，每秒放入 rate 个令牌，最多攒 burst 个，允许一定的突发流量
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	// 上一次放入令牌的时间，用的时候再按照流逝的时间补上，不需要定时器
+	last time.Time
+	now  func() time.Time
+}
+
+// NewTokenBucket 一开始桶是满的
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+func (t *TokenBucket) Allow() (DoneFunc, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	if elapsed := now.Sub(t.last); elapsed > 0 {
+		t.tokens = math.Min(t.burst, t.tokens+elapsed.Seconds()*t.rate)
+		t.last = now
+	}
+	if t.tokens < 1 {
+		return nil, ErrLimitExceeded
+	}
+	t.tokens--
+	return noop, nil
+}
@@ -0,0 +1,477 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/internal/watcher"
+)
+
+const (
+	// Version 和 Group 放在 Meta 里面，读取的时候去掉
+	metaVersion = "micro_version"
+	metaGroup   = "micro_group"
+)
+
+var ErrRegistryClosed = errors.New("micro: 注册中心已经关闭")
+
+// minWatchInterval 阻塞查询很快返回但是 index 没有前进的时候，至少隔这么久再查询，避免一直空转
+const minWatchInterval = time.Second
+
+// Registry 通过 Consul 的 HTTP 接口注册和发现服务
+// 注册的时候带上 TTL 检查，并且定时上报检查通过；Subscribe 使用阻塞查询
+// 发现的时候只返回检查通过的实例，进程挂掉之后 TTL 一过期就不会再被发现
+// Weight 为 0 的实例注册的时候使用 Consul 默认的权重，读回来是 1，和 0 当作 1 的约定一致
+type Registry struct {
+	addr   string
+	client *http.Client
+	token  string
+	ttl    time.Duration
+	// 阻塞查询最长等待的时间
+	wait time.Duration
+
+	mutex sync.Mutex
+	// 服务 ID -> 停止心跳
+	heartbeats map[string]context.CancelFunc
+	closed     bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+type RegistryOption func(*Registry)
+
+func RegistryWithHTTPClient(client *http.Client) RegistryOption {
+	return func(r *Registry) {
+		r.client = client
+	}
+}
+
+// RegistryWithToken 设置 ACL token
+func RegistryWithToken(token string) RegistryOption {
+	return func(r *Registry) {
+		r.token = token
+	}
+}
+
+// RegistryWithTTL 设置 TTL 检查的时间，每过 TTL 的三分之一上报一次，默认 15 秒
+func RegistryWithTTL(ttl time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// RegistryWithWaitTime 设置阻塞查询的等待时间，默认一分钟
+func RegistryWithWaitTime(wait time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.wait = wait
+	}
+}
+
+// NewRegistry addr 是 Consul agent 的地址，例如 http://127.0.0.1:8500
+func NewRegistry(addr string, opts ...RegistryOption) (*Registry, error) {
+	if _, err := url.Parse(addr); err != nil {
+		return nil, fmt.Errorf("micro: 非法的 Consul 地址 %s: %w", addr, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res := &Registry{
+		addr:       strings.TrimSuffix(addr, "/"),
+		client:     http.DefaultClient,
+		ttl:        15 * time.Second,
+		wait:       time.Minute,
+		heartbeats: make(map[string]context.CancelFunc, 4),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res, nil
+}
+
+// Register 重复注册同一个实例会更新实例的信息
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	svc, err := newAgentService(si, r.ttl)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	closed := r.closed
+	r.mutex.Unlock()
+	if closed {
+		return ErrRegistryClosed
+	}
+	// 请求 Consul 的时候不持有锁
+	if err = r.do(ctx, http.MethodPut, "/v1/agent/service/register", svc, nil); err != nil {
+		return err
+	}
+	// 注册之后马上上报一次，不然要等到 TTL 过去才会变成健康状态
+	if err = r.pass(ctx, svc.ID); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		// 注册的时候被关闭了，Close 不知道这个实例，自己注销
+		_ = r.deregister(svc.ID)
+		return ErrRegistryClosed
+	}
+	if _, ok := r.heartbeats[svc.ID]; !ok {
+		hbCtx, cancel := context.WithCancel(r.ctx)
+		r.heartbeats[svc.ID] = cancel
+		go r.heartbeat(hbCtx, svc.ID)
+	}
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	id := serviceID(si)
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return ErrRegistryClosed
+	}
+	if cancel, ok := r.heartbeats[id]; ok {
+		cancel()
+		delete(r.heartbeats, id)
+	}
+	r.mutex.Unlock()
+	return r.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil)
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	r.mutex.Lock()
+	closed := r.closed
+	r.mutex.Unlock()
+	if closed {
+		return nil, ErrRegistryClosed
+	}
+	ins, _, err := r.query(ctx, serviceName, 0)
+	return ins, err
+}
+
+// Subscribe 使用阻塞查询，服务有变化的时候 Consul 才会返回，和上一次的结果比较之后通知变化
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	r.mutex.Lock()
+	closed := r.closed
+	r.mutex.Unlock()
+	if closed {
+		return nil, ErrRegistryClosed
+	}
+	// 订阅时的结果作为基准，订阅方应该自己先 ListServices
+	// 查询的时候被关闭了，r.ctx 已经取消，watch 马上退出
+	ins, index, _ := r.query(r.ctx, serviceName, 0)
+	w := watcher.New(r.ctx.Done())
+	go r.watch(serviceName, w, toMap(ins), index)
+	return w.Events(), nil
+}
+
+// Close 注销所有通过这个注册中心注册的实例，并且结束所有订阅
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.cancel()
+	heartbeats := r.heartbeats
+	r.heartbeats = nil
+	r.mutex.Unlock()
+	var err error
+	for id := range heartbeats {
+		if er := r.deregister(id); er != nil {
+			err = er
+		}
+	}
+	return err
+}
+
+// deregister Close 之后 r.ctx 已经取消，用单独的超时时间注销
+func (r *Registry) deregister(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	return r.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil)
+}
+
+func (r *Registry) heartbeat(ctx context.Context, id string) {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 失败了也没关系，下一次上报成功之前 TTL 没过期就行
+			_ = r.pass(ctx, id)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Registry) pass(ctx context.Context, id string) error {
+	return r.do(ctx, http.MethodPut, "/v1/agent/check/pass/"+url.PathEscape(checkID(id)), nil, nil)
+}
+
+func (r *Registry) watch(serviceName string, w *watcher.Watcher, last map[string]registry.ServiceInstance, index uint64) {
+	for {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(r.ctx, r.wait+r.wait/16+time.Second)
+		ins, newIndex, err := r.query(ctx, serviceName, index)
+		cancel()
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.Push(registry.Event{Type: registry.EventError, Err: err})
+			// 不要一直重试，等一会儿
+			select {
+			case <-time.After(time.Second):
+			case <-r.ctx.Done():
+				return
+			}
+			continue
+		}
+		advanced := newIndex > index
+		// index 变小说明 Consul 的数据被重置了，要从头开始
+		// index 至少是 1，不然下一次查询不会阻塞
+		if newIndex < index || newIndex == 0 {
+			newIndex = 1
+		}
+		index = newIndex
+		cur := toMap(ins)
+		for _, e := range diff(last, cur) {
+			w.Push(e)
+		}
+		last = cur
+		if !advanced {
+			select {
+			case <-time.After(minWatchInterval - time.Since(start)):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// query 只查询检查通过的实例，index 大于 0 的时候是阻塞查询
+func (r *Registry) query(ctx context.Context, serviceName string, index uint64) ([]registry.ServiceInstance, uint64, error) {
+	path := "/v1/health/service/" + url.PathEscape(serviceName) + "?passing=true"
+	if index > 0 {
+		path += fmt.Sprintf("&index=%d&wait=%dms", index, r.wait.Milliseconds())
+	}
+	var services []healthService
+	header, err := r.doWithHeader(ctx, http.MethodGet, path, nil, &services)
+	if err != nil {
+		return nil, 0, err
+	}
+	newIndex, err := strconv.ParseUint(header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("micro: Consul 返回了非法的 X-Consul-Index: %w", err)
+	}
+	res := make([]registry.ServiceInstance, 0, len(services))
+	for _, svc := range services {
+		res = append(res, svc.toServiceInstance())
+	}
+	return res, newIndex, nil
+}
+
+func (r *Registry) do(ctx context.Context, method, path string, body, result any) error {
+	_, err := r.doWithHeader(ctx, method, path, body, result)
+	return err
+}
+
+func (r *Registry) doWithHeader(ctx context.Context, method, path string, body, result any) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.addr+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if r.token != "" {
+		req.Header.Set("X-Consul-Token", r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("micro: Consul 返回 %d %s: %s", resp.StatusCode, path, strings.TrimSpace(string(msg)))
+	}
+	if result != nil {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+func serviceID(si registry.ServiceInstance) string {
+	return si.Name + "-" + si.Address
+}
+
+// checkID 和服务一起注册的检查，Consul 默认的 ID
+func checkID(id string) string {
+	return "service:" + id
+}
+
+func toMap(ins []registry.ServiceInstance) map[string]registry.ServiceInstance {
+	res := make(map[string]registry.ServiceInstance, len(ins))
+	for _, si := range ins {
+		res[si.Address] = si
+	}
+	return res
+}
+
+// diff 新增的和变化的在前，删除的在后
+func diff(last, cur map[string]registry.ServiceInstance) []registry.Event {
+	var res []registry.Event
+	for addr, si := range cur {
+		old, ok := last[addr]
+		switch {
+		case !ok:
+			res = append(res, registry.Event{Type: registry.EventAdd, Instance: si})
+		case !reflect.DeepEqual(old, si):
+			res = append(res, registry.Event{Type: registry.EventUpdate, Instance: si})
+		}
+	}
+	for addr, si := range last {
+		if _, ok := cur[addr]; !ok {
+			res = append(res, registry.Event{Type: registry.EventDelete, Instance: si})
+		}
+	}
+	return res
+}
+
+type weights struct {
+	Passing int
+	Warning int
+}
+
+type agentCheck struct {
+	TTL                            string
+	DeregisterCriticalServiceAfter string
+}
+
+// agentService /v1/agent/service/register 的请求
+type agentService struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string          `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Weights *weights          `json:",omitempty"`
+	Check   agentCheck
+}
+
+func newAgentService(si registry.ServiceInstance, ttl time.Duration) (agentService, error) {
+	host, portStr, err := net.SplitHostPort(si.Address)
+	if err != nil {
+		return agentService{}, fmt.Errorf("micro: 非法的实例地址 %s: %w", si.Address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return agentService{}, fmt.Errorf("micro: 非法的实例地址 %s: %w", si.Address, err)
+	}
+	res := agentService{
+		ID:      serviceID(si),
+		Name:    si.Name,
+		Address: host,
+		Port:    port,
+		Tags:    si.Tags,
+		Check: agentCheck{
+			TTL: ttl.String(),
+			// 进程挂掉之后，过一段时间自动注销
+			DeregisterCriticalServiceAfter: (ttl * 10).String(),
+		},
+	}
+	if si.Weight > 0 {
+		res.Weights = &weights{Passing: int(si.Weight), Warning: 1}
+	}
+	if len(si.Metadata) > 0 || si.Version != "" || si.Group != "" {
+		res.Meta = make(map[string]string, len(si.Metadata)+2)
+		for k, v := range si.Metadata {
+			res.Meta[k] = v
+		}
+		if si.Version != "" {
+			res.Meta[metaVersion] = si.Version
+		}
+		if si.Group != "" {
+			res.Meta[metaGroup] = si.Group
+		}
+	}
+	return res, nil
+}
+
+// healthService /v1/health/service/:service 返回的元素
+type healthService struct {
+	Node    healthNode
+	Service healthServiceEntry
+}
+
+type healthNode struct {
+	Address string
+}
+
+type healthServiceEntry struct {
+	ID      string
+	Service string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Weights weights
+}
+
+func (h healthService) toServiceInstance() registry.ServiceInstance {
+	c := h.Service
+	// 注册的时候没有指定地址，Consul 返回的是空的，实际用的是节点的地址
+	host := c.Address
+	if host == "" {
+		host = h.Node.Address
+	}
+	res := registry.ServiceInstance{
+		Name:    c.Service,
+		Address: net.JoinHostPort(host, strconv.Itoa(c.Port)),
+		Weight:  uint32(c.Weights.Passing),
+	}
+	if len(c.Tags) > 0 {
+		res.Tags = c.Tags
+	}
+	for k, v := range c.Meta {
+		switch k {
+		case metaVersion:
+			res.Version = v
+		case metaGroup:
+			res.Group = v
+		default:
+			if res.Metadata == nil {
+				res.Metadata = make(map[string]string, len(c.Meta))
+			}
+			res.Metadata[k] = v
+		}
+	}
+	return res
+}
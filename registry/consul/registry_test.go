@@ -0,0 +1,261 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/registrytest"
+)
+
+func TestRegistry_Conformance(t *testing.T) {
+	registrytest.Run(t, func(t *testing.T) registry.Registry {
+		server := httptest.NewServer(newFakeConsul())
+		t.Cleanup(server.Close)
+		r, err := NewRegistry(server.URL, RegistryWithWaitTime(time.Second))
+		require.NoError(t, err)
+		return r
+	})
+}
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	consul := newFakeConsul()
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	r, err := NewRegistry(server.URL, RegistryWithTTL(30*time.Millisecond))
+	require.NoError(t, err)
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	require.NoError(t, r.Register(context.Background(), si))
+	time.Sleep(100 * time.Millisecond)
+	// 注册的时候一次，之后每 10ms 一次
+	assert.GreaterOrEqual(t, consul.passCount("service:user-service-127.0.0.1:8081"), 5)
+	assert.Equal(t, "30ms", consul.service("user-service-127.0.0.1:8081").Check.TTL)
+
+	// 关闭的时候注销实例，不再上报
+	require.NoError(t, r.Close())
+	assert.Equal(t, agentService{}, consul.service("user-service-127.0.0.1:8081"))
+	cnt := consul.passCount("service:user-service-127.0.0.1:8081")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, cnt, consul.passCount("service:user-service-127.0.0.1:8081"))
+}
+
+func TestRegistry_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+	}))
+	defer server.Close()
+	r, err := NewRegistry(server.URL)
+	require.NoError(t, err)
+	defer r.Close()
+	err = r.Register(context.Background(), registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"})
+	assert.ErrorContains(t, err, "ACL not found")
+	err = r.Register(context.Background(), registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1"})
+	assert.Error(t, err)
+	_, err = r.ListServices(context.Background(), "user-service")
+	assert.Error(t, err)
+}
+
+// fakeConsul 只实现了用到的几个接口，阻塞查询在 index 变化或者等待超时之后返回
+type fakeConsul struct {
+	mutex    sync.Mutex
+	index    uint64
+	services map[string]agentService
+	passes   map[string]int
+	// 检查 ID -> 是否通过，注册之后上报之前是不通过的
+	passing map[string]bool
+	// index 变化的时候关闭
+	changed chan struct{}
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		services: make(map[string]agentService),
+		passes:   make(map[string]int),
+		passing:  make(map[string]bool),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == http.MethodPut && req.URL.Path == "/v1/agent/service/register":
+		var svc agentService
+		if err := json.NewDecoder(req.Body).Decode(&svc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.update(func() {
+			f.services[svc.ID] = svc
+			// 重复注册的时候保留原来的状态
+			if _, ok := f.passing[checkID(svc.ID)]; !ok {
+				f.passing[checkID(svc.ID)] = false
+			}
+		})
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/")
+		f.update(func() {
+			delete(f.services, id)
+			delete(f.passing, checkID(id))
+		})
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/v1/agent/check/pass/"):
+		id := strings.TrimPrefix(req.URL.Path, "/v1/agent/check/pass/")
+		f.mutex.Lock()
+		f.passes[id]++
+		f.mutex.Unlock()
+		f.setPassing(id, true)
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/v1/health/service/"):
+		f.health(w, req, strings.TrimPrefix(req.URL.Path, "/v1/health/service/"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeConsul) health(w http.ResponseWriter, req *http.Request, name string) {
+	index, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+	wait, _ := time.ParseDuration(req.URL.Query().Get("wait"))
+	f.mutex.Lock()
+	if index > 0 && index >= f.index {
+		changed := f.changed
+		f.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return
+		}
+		f.mutex.Lock()
+	}
+	passingOnly := req.URL.Query().Get("passing") == "true"
+	res := make([]healthService, 0, len(f.services))
+	for _, svc := range f.services {
+		if svc.Name != name || passingOnly && !f.passing[checkID(svc.ID)] {
+			continue
+		}
+		hs := healthService{
+			Node: healthNode{Address: "127.0.0.1"},
+			Service: healthServiceEntry{
+				ID:      svc.ID,
+				Service: svc.Name,
+				Address: svc.Address,
+				Port:    svc.Port,
+				Tags:    svc.Tags,
+				Meta:    svc.Meta,
+				Weights: weights{Passing: 1, Warning: 1},
+			},
+		}
+		if svc.Weights != nil {
+			hs.Service.Weights = *svc.Weights
+		}
+		res = append(res, hs)
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	f.mutex.Unlock()
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeConsul) update(fn func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fn()
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// setPassing 检查的状态变化的时候 index 才会变化
+func (f *fakeConsul) setPassing(id string, passing bool) {
+	f.mutex.Lock()
+	old, ok := f.passing[id]
+	f.mutex.Unlock()
+	if ok && old != passing {
+		f.update(func() {
+			f.passing[id] = passing
+		})
+	}
+}
+
+func (f *fakeConsul) passCount(id string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.passes[id]
+}
+
+func (f *fakeConsul) service(id string) agentService {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.services[id]
+}
+
+// TestRegistry_WatchBackoff 阻塞查询不阻塞、index 也不前进的时候，不会一直空转
+func TestRegistry_WatchBackoff(t *testing.T) {
+	testCases := []struct {
+		name  string
+		index string
+	}{
+		{
+			name:  "zero index",
+			index: "0",
+		},
+		{
+			name:  "invalid index",
+			index: "abc",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cnt int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				atomic.AddInt64(&cnt, 1)
+				w.Header().Set("X-Consul-Index", tc.index)
+				_, _ = w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+			r, err := NewRegistry(server.URL)
+			require.NoError(t, err)
+			defer r.Close()
+			_, err = r.Subscribe("user-service")
+			require.NoError(t, err)
+			time.Sleep(500 * time.Millisecond)
+			// 订阅的时候一次，之后马上查询一次，然后至少等 minWatchInterval
+			assert.LessOrEqual(t, atomic.LoadInt64(&cnt), int64(2))
+		})
+	}
+}
+
+// TestRegistry_Passing 检查没有通过的实例不会被发现，TTL 过期之后订阅方会收到删除的事件
+func TestRegistry_Passing(t *testing.T) {
+	consul := newFakeConsul()
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	r, err := NewRegistry(server.URL, RegistryWithWaitTime(time.Second))
+	require.NoError(t, err)
+	defer r.Close()
+	a := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 1}
+	b := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082", Weight: 1}
+	require.NoError(t, r.Register(context.Background(), a))
+	require.NoError(t, r.Register(context.Background(), b))
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+
+	// 模拟 b 的进程挂掉，TTL 过期
+	consul.setPassing(checkID(serviceID(b)), false)
+	select {
+	case e := <-events:
+		assert.Equal(t, registry.Event{Type: registry.EventDelete, Instance: b}, e)
+	case <-time.After(time.Second):
+		t.Fatal("没有收到事件")
+	}
+	ins, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{a}, ins)
+}
@@ -0,0 +1,245 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/internal/watcher"
+)
+
+var (
+	ErrUnsupported    = errors.New("micro: DNS 注册中心是只读的，不支持注册和注销")
+	ErrRegistryClosed = errors.New("micro: 注册中心已经关闭")
+)
+
+// Resolver DNS 查询，*net.Resolver 实现了这个接口，测试的时候可以替换
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Registry 通过 DNS 发现服务，只读
+// 先查询 SRV 记录，没有的时候退化成查询 A/AAAA 记录，端口使用 RegistryWithPort 设置的端口
+// SRV 记录只使用 Priority 最小的那一组，其余的是备份
+type Registry struct {
+	resolver Resolver
+	// 域名模板，{service} 会被替换成服务名
+	template string
+	port     int
+	interval time.Duration
+	timeout  time.Duration
+
+	mutex   sync.Mutex
+	closed  bool
+	closeCh chan struct{}
+}
+
+type RegistryOption func(*Registry)
+
+// RegistryWithResolver 默认使用 net.DefaultResolver
+func RegistryWithResolver(resolver Resolver) RegistryOption {
+	return func(r *Registry) {
+		r.resolver = resolver
+	}
+}
+
+// RegistryWithTemplate 设置域名模板，例如 _grpc._tcp.{service}.svc.cluster.local
+// 默认就是服务名本身
+func RegistryWithTemplate(template string) RegistryOption {
+	return func(r *Registry) {
+		r.template = template
+	}
+}
+
+// RegistryWithPort 只有 A/AAAA 记录的时候使用的端口
+func RegistryWithPort(port int) RegistryOption {
+	return func(r *Registry) {
+		r.port = port
+	}
+}
+
+// RegistryWithInterval 订阅的时候查询的间隔，默认 30 秒
+func RegistryWithInterval(interval time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+// RegistryWithTimeout 订阅的时候每次查询的超时时间，默认 5 秒
+func RegistryWithTimeout(timeout time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.timeout = timeout
+	}
+}
+
+func NewRegistry(opts ...RegistryOption) *Registry {
+	res := &Registry{
+		resolver: net.DefaultResolver,
+		template: "{service}",
+		interval: 30 * time.Second,
+		timeout:  5 * time.Second,
+		closeCh:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	return ErrUnsupported
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	return ErrUnsupported
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	if r.isClosed() {
+		return nil, ErrRegistryClosed
+	}
+	domain := strings.ReplaceAll(r.template, "{service}", serviceName)
+	_, srvs, err := r.resolver.LookupSRV(ctx, "", "", domain)
+	if err == nil && len(srvs) > 0 {
+		priority := srvs[0].Priority
+		for _, srv := range srvs {
+			if srv.Priority < priority {
+				priority = srv.Priority
+			}
+		}
+		res := make([]registry.ServiceInstance, 0, len(srvs))
+		for _, srv := range srvs {
+			if srv.Priority != priority {
+				continue
+			}
+			res = append(res, registry.ServiceInstance{
+				Name:    serviceName,
+				Address: net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+				Weight:  uint32(srv.Weight),
+			})
+		}
+		return res, nil
+	}
+	// 只有 SRV 记录不存在的时候才退化，其余的错误直接返回
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	if r.port == 0 {
+		return []registry.ServiceInstance{}, nil
+	}
+	hosts, err := r.resolver.LookupHost(ctx, domain)
+	if isNotFound(err) {
+		return []registry.ServiceInstance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]registry.ServiceInstance, 0, len(hosts))
+	for _, host := range hosts {
+		res = append(res, registry.ServiceInstance{
+			Name:    serviceName,
+			Address: net.JoinHostPort(host, strconv.Itoa(r.port)),
+		})
+	}
+	return res, nil
+}
+
+// Subscribe 定时查询，和上一次的结果比较之后通知变化
+// 查询失败的时候通知 EventError，下一次查询成功之后继续比较
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	w := watcher.New(r.closeCh)
+	go r.poll(serviceName, w)
+	return w.Events(), nil
+}
+
+// poll 第一次查询的结果作为基准，订阅方应该自己先 ListServices
+// 在这里查询，DNS 很慢的时候 Subscribe 也不会被阻塞
+func (r *Registry) poll(serviceName string, w *watcher.Watcher) {
+	// 失败的时候没有基准，下一次查询成功之后全部当作新增
+	last, _ := r.lookup(serviceName)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cur, err := r.lookup(serviceName)
+			if err != nil {
+				w.Push(registry.Event{Type: registry.EventError, Err: err})
+				continue
+			}
+			for _, e := range diff(last, cur) {
+				w.Push(e)
+			}
+			last = cur
+		case <-r.closeCh:
+			return
+		}
+	}
+}
+
+func (r *Registry) lookup(serviceName string) (map[string]registry.ServiceInstance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	ins, err := r.ListServices(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]registry.ServiceInstance, len(ins))
+	for _, si := range ins {
+		res[si.Address] = si
+	}
+	return res, nil
+}
+
+// diff 新增的和变化的在前，删除的在后
+func diff(last, cur map[string]registry.ServiceInstance) []registry.Event {
+	var res []registry.Event
+	for addr, si := range cur {
+		old, ok := last[addr]
+		switch {
+		case !ok:
+			res = append(res, registry.Event{Type: registry.EventAdd, Instance: si})
+		case !reflect.DeepEqual(old, si):
+			res = append(res, registry.Event{Type: registry.EventUpdate, Instance: si})
+		}
+	}
+	for addr, si := range last {
+		if _, ok := cur[addr]; !ok {
+			res = append(res, registry.Event{Type: registry.EventDelete, Instance: si})
+		}
+	}
+	return res
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func (r *Registry) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.closeCh)
+	return nil
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"web/micro/registry"
+)
+
+func TestRegistry_ListServices(t *testing.T) {
+	testCases := []struct {
+		name     string
+		resolver *stubResolver
+		opts     []RegistryOption
+		wantIns  []registry.ServiceInstance
+		wantErr  bool
+	}{
+		{
+			name: "srv",
+			resolver: &stubResolver{srv: map[string][]*net.SRV{
+				"_grpc._tcp.user-service.local": {
+					{Target: "a.local.", Port: 8081, Weight: 10},
+					{Target: "b.local.", Port: 8082},
+				},
+			}},
+			opts: []RegistryOption{RegistryWithTemplate("_grpc._tcp.{service}.local")},
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", Address: "a.local:8081", Weight: 10},
+				{Name: "user-service", Address: "b.local:8082"},
+			},
+		},
+		{
+			name: "srv priority",
+			resolver: &stubResolver{srv: map[string][]*net.SRV{
+				"user-service": {
+					{Target: "a.local.", Port: 8081, Priority: 10},
+					{Target: "b.local.", Port: 8082, Priority: 20},
+					{Target: "c.local.", Port: 8083, Priority: 10},
+				},
+			}},
+			// 只使用 Priority 最小的一组
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", Address: "a.local:8081"},
+				{Name: "user-service", Address: "c.local:8083"},
+			},
+		},
+		{
+			name: "host fallback",
+			resolver: &stubResolver{host: map[string][]string{
+				"user-service.local": {"10.0.0.1", "::1"},
+			}},
+			opts: []RegistryOption{RegistryWithTemplate("{service}.local"), RegistryWithPort(8081)},
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", Address: "10.0.0.1:8081"},
+				{Name: "user-service", Address: "[::1]:8081"},
+			},
+		},
+		{
+			name:     "no port",
+			resolver: &stubResolver{host: map[string][]string{"user-service": {"10.0.0.1"}}},
+			wantIns:  []registry.ServiceInstance{},
+		},
+		{
+			name:     "not found",
+			resolver: &stubResolver{},
+			opts:     []RegistryOption{RegistryWithPort(8081)},
+			wantIns:  []registry.ServiceInstance{},
+		},
+		{
+			name:     "srv error",
+			resolver: &stubResolver{err: errors.New("timeout")},
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry(append(tc.opts, RegistryWithResolver(tc.resolver))...)
+			defer r.Close()
+			ins, err := r.ListServices(context.Background(), "user-service")
+			assert.Equal(t, tc.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantIns, ins)
+		})
+	}
+}
+
+func TestRegistry_Subscribe(t *testing.T) {
+	resolver := &stubResolver{srv: map[string][]*net.SRV{
+		"user-service": {{Target: "a.local.", Port: 8081}},
+	}}
+	r := NewRegistry(RegistryWithResolver(resolver), RegistryWithInterval(10*time.Millisecond))
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+	// 等第一次查询拿到基准
+	require.Eventually(t, func() bool {
+		return resolver.lookupCount() > 0
+	}, time.Second, time.Millisecond)
+
+	resolver.setSRV("user-service", []*net.SRV{{Target: "b.local.", Port: 8082}})
+	got := map[registry.EventType]string{}
+	for i := 0; i < 2; i++ {
+		e := <-events
+		got[e.Type] = e.Instance.Address
+	}
+	assert.Equal(t, map[registry.EventType]string{
+		registry.EventAdd:    "b.local:8082",
+		registry.EventDelete: "a.local:8081",
+	}, got)
+
+	assert.Equal(t, ErrUnsupported, r.Register(context.Background(), registry.ServiceInstance{}))
+	require.NoError(t, r.Close())
+	for range events {
+	}
+}
+
+// TestRegistry_SubscribeSlowDNS DNS 查询很慢的时候，Subscribe 也马上返回
+func TestRegistry_SubscribeSlowDNS(t *testing.T) {
+	resolver := &stubResolver{block: make(chan struct{})}
+	r := NewRegistry(RegistryWithResolver(resolver))
+	defer r.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := r.Subscribe("user-service")
+		assert.NoError(t, err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe 被 DNS 查询阻塞了")
+	}
+	close(resolver.block)
+}
+
+type stubResolver struct {
+	mutex sync.Mutex
+	srv   map[string][]*net.SRV
+	host  map[string][]string
+	err   error
+	// 不为 nil 的时候，LookupSRV 等到它关闭才返回
+	block   chan struct{}
+	lookups int
+}
+
+func (s *stubResolver) lookupCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lookups
+}
+
+func (s *stubResolver) setSRV(name string, srvs []*net.SRV) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.srv[name] = srvs
+}
+
+func (s *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lookups++
+	if s.err != nil {
+		return "", nil, s.err
+	}
+	srvs, ok := s.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, srvs, nil
+}
+
+func (s *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	hosts, ok := s.host[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return hosts, nil
+}
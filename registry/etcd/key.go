@@ -0,0 +1,57 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+	"web/micro/registry"
+)
+
+// KeyCodec 决定实例在 etcd 里面的 key，和其它框架互通的时候可以换成它们的格式
+type KeyCodec interface {
+	// InstanceKey 实例的 key
+	InstanceKey(si registry.ServiceInstance) string
+	// ServicePrefix 服务下所有实例的 key 的前缀
+	// 应该以分隔符结尾，不然 user 会匹配到 user-admin
+	ServicePrefix(serviceName string) string
+	// Decode 从 key 里面解析出服务名和地址，删除事件拿不到 value 的时候使用
+	Decode(key string) (registry.ServiceInstance, error)
+}
+
+// PathCodec 默认的 key 格式：根路径/命名空间.../服务名/地址，例如 /micro/prod/user-service/127.0.0.1:8081
+type PathCodec struct {
+	// 以 / 结尾
+	base string
+}
+
+// NewPathCodec root 为空的时候使用 /micro，namespaces 按顺序拼接在 root 后面
+func NewPathCodec(root string, namespaces ...string) PathCodec {
+	if root == "" {
+		root = "/micro"
+	}
+	segs := []string{strings.TrimRight(root, "/")}
+	for _, ns := range namespaces {
+		if ns = strings.Trim(ns, "/"); ns != "" {
+			segs = append(segs, ns)
+		}
+	}
+	return PathCodec{base: strings.Join(segs, "/") + "/"}
+}
+
+func (p PathCodec) InstanceKey(si registry.ServiceInstance) string {
+	return p.ServicePrefix(si.Name) + si.Address
+}
+
+func (p PathCodec) ServicePrefix(serviceName string) string {
+	return p.base + serviceName + "/"
+}
+
+func (p PathCodec) Decode(key string) (registry.ServiceInstance, error) {
+	if !strings.HasPrefix(key, p.base) {
+		return registry.ServiceInstance{}, fmt.Errorf("micro: 非法的实例 key %s", key)
+	}
+	segs := strings.SplitN(strings.TrimPrefix(key, p.base), "/", 2)
+	if len(segs) != 2 || segs[0] == "" || segs[1] == "" {
+		return registry.ServiceInstance{}, fmt.Errorf("micro: 非法的实例 key %s", key)
+	}
+	return registry.ServiceInstance{Name: segs[0], Address: segs[1]}, nil
+}
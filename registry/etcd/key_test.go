@@ -0,0 +1,70 @@
+package etcd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"web/micro/registry"
+)
+
+func TestPathCodec(t *testing.T) {
+	si := registry.ServiceInstance{Name: "user", Address: "127.0.0.1:8081"}
+	testCases := []struct {
+		name       string
+		codec      PathCodec
+		wantKey    string
+		wantPrefix string
+	}{
+		{
+			name:       "default",
+			codec:      NewPathCodec(""),
+			wantKey:    "/micro/user/127.0.0.1:8081",
+			wantPrefix: "/micro/user/",
+		},
+		{
+			name:       "namespace",
+			codec:      NewPathCodec("/micro", "prod", "/sh/"),
+			wantKey:    "/micro/prod/sh/user/127.0.0.1:8081",
+			wantPrefix: "/micro/prod/sh/user/",
+		},
+		{
+			name:       "root with trailing slash",
+			codec:      NewPathCodec("/services/"),
+			wantKey:    "/services/user/127.0.0.1:8081",
+			wantPrefix: "/services/user/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := tc.codec.InstanceKey(si)
+			assert.Equal(t, tc.wantKey, key)
+			assert.Equal(t, tc.wantPrefix, tc.codec.ServicePrefix(si.Name))
+			decoded, err := tc.codec.Decode(key)
+			assert.NoError(t, err)
+			assert.Equal(t, si, decoded)
+			// user 的前缀不能匹配到 user-admin
+			admin := tc.codec.InstanceKey(registry.ServiceInstance{Name: "user-admin", Address: "127.0.0.1:8082"})
+			assert.NotContains(t, admin, tc.wantPrefix)
+		})
+	}
+}
+
+func TestPathCodec_Decode(t *testing.T) {
+	codec := NewPathCodec("/micro", "prod")
+	testCases := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "other namespace", key: "/micro/test/user/127.0.0.1:8081", wantErr: true},
+		{name: "no address", key: "/micro/prod/user/", wantErr: true},
+		{name: "no service", key: "/micro/prod/", wantErr: true},
+		{name: "normal", key: "/micro/prod/user/127.0.0.1:8081"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := codec.Decode(tc.key)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
@@ -3,29 +3,179 @@ package etcd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientV3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"sync"
+	"time"
 	"web/micro/registry"
+	"web/micro/registry/internal/watcher"
 )
 
+var ErrRegistryClosed = errors.New("micro: 注册中心已经关闭")
+
+// retryInterval 重建租约、重新监听失败之后，等待多久再试
+const retryInterval = time.Second
+
 type Registry struct {
-	c       *clientV3.Client
-	sess    *concurrency.Session
-	cancels []func()
-	mutex   sync.Mutex
+	c     *clientV3.Client
+	mutex sync.Mutex
+	sess  *concurrency.Session
+	// 租约的 TTL，单位是秒
+	ttl   int
+	codec KeyCodec
+	// 没有指定 codec 的时候，用来创建默认的 PathCodec
+	root       string
+	namespaces []string
+	// 已经注册的实例，key -> value，租约丢失之后要重新写入
+	instances map[string]string
+	closed    bool
+	// Close 的时候取消，所有的订阅和后台协程都会退出
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type RegistryOption func(*Registry)
+
+// RegistryWithTTL 设置租约的 TTL，单位是秒，默认是 60 秒
+func RegistryWithTTL(ttl int) RegistryOption {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// RegistryWithRoot 设置 key 的根路径，默认是 /micro
+func RegistryWithRoot(root string) RegistryOption {
+	return func(r *Registry) {
+		r.root = root
+	}
+}
+
+// RegistryWithNamespace 在根路径后面加上命名空间，例如环境 /micro/prod/...
+func RegistryWithNamespace(namespaces ...string) RegistryOption {
+	return func(r *Registry) {
+		r.namespaces = append(r.namespaces, namespaces...)
+	}
 }
 
-func NewRegistry(c *clientV3.Client) (*Registry, error) {
-	sess, err := concurrency.NewSession(c)
+// RegistryWithKeyCodec 使用自定义的 key 格式，设置之后 RegistryWithRoot 和 RegistryWithNamespace 不再生效
+func RegistryWithKeyCodec(codec KeyCodec) RegistryOption {
+	return func(r *Registry) {
+		r.codec = codec
+	}
+}
+
+func NewRegistry(c *clientV3.Client, opts ...RegistryOption) (*Registry, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	res := &Registry{
+		c:         c,
+		ttl:       60,
+		instances: make(map[string]string, 4),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.codec == nil {
+		res.codec = NewPathCodec(res.root, res.namespaces...)
+	}
+	sess, err := res.newSession()
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-	return &Registry{
-		c:    c,
-		sess: sess,
-	}, nil
+	res.sess = sess
+	go res.keepAlive(sess)
+	return res, nil
+}
+
+func (r *Registry) newSession() (*concurrency.Session, error) {
+	return concurrency.NewSession(r.c, concurrency.WithTTL(r.ttl), concurrency.WithContext(r.ctx))
+}
+
+// keepAlive 租约丢失之后（网络分区、etcd 重启），重新创建租约并且重新注册所有的实例
+func (r *Registry) keepAlive(sess *concurrency.Session) {
+	for {
+		select {
+		case <-sess.Done():
+		case <-r.ctx.Done():
+			return
+		}
+		for {
+			var err error
+			sess, err = r.recover()
+			if err == nil {
+				break
+			}
+			select {
+			case <-time.After(retryInterval):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// recover 用新的租约重新写入所有的实例
+// 写入的时候不持有锁，期间 Register 和 UnRegister 造成的变化在下一轮补上，直到没有变化为止
+func (r *Registry) recover() (*concurrency.Session, error) {
+	sess, err := r.newSession()
+	if err != nil {
+		return nil, err
+	}
+	// 已经用新租约写入的实例
+	written := make(map[string]string, 4)
+	for {
+		r.mutex.Lock()
+		if r.closed {
+			r.mutex.Unlock()
+			_ = sess.Close()
+			return nil, context.Canceled
+		}
+		puts := make(map[string]string, len(r.instances))
+		for key, val := range r.instances {
+			if written[key] != val {
+				puts[key] = val
+			}
+		}
+		var deletes []string
+		for key := range written {
+			if _, ok := r.instances[key]; !ok {
+				deletes = append(deletes, key)
+			}
+		}
+		if len(puts) == 0 && len(deletes) == 0 {
+			r.sess = sess
+			r.mutex.Unlock()
+			return sess, nil
+		}
+		r.mutex.Unlock()
+
+		for key, val := range puts {
+			ctx, cancel := context.WithTimeout(r.ctx, time.Second*3)
+			_, err = r.c.Put(ctx, key, val, clientV3.WithLease(sess.Lease()))
+			cancel()
+			if err != nil {
+				// 新的租约不要了，下一次从头再来
+				_ = sess.Close()
+				return nil, err
+			}
+			written[key] = val
+		}
+		for _, key := range deletes {
+			ctx, cancel := context.WithTimeout(r.ctx, time.Second*3)
+			_, err = r.c.Delete(ctx, key)
+			cancel()
+			if err != nil {
+				_ = sess.Close()
+				return nil, err
+			}
+			delete(written, key)
+		}
+	}
 }
 
 func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
@@ -33,19 +183,33 @@ func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) er
 	if err != nil {
 		return err
 	}
+	key := r.codec.InstanceKey(si)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrRegistryClosed
+	}
 	// option的地方传入租约
-	_, err = r.c.Put(ctx, r.instanceKey(si), string(val), clientV3.WithLease(r.sess.Lease()))
-	return err
+	_, err = r.c.Put(ctx, key, string(val), clientV3.WithLease(r.sess.Lease()))
+	if err != nil {
+		return err
+	}
+	r.instances[key] = string(val)
+	return nil
 }
 
 func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
-	_, err := r.c.Delete(ctx, r.instanceKey(si))
+	key := r.codec.InstanceKey(si)
+	r.mutex.Lock()
+	delete(r.instances, key)
+	r.mutex.Unlock()
+	_, err := r.c.Delete(ctx, key)
 	return err
 }
 
 func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
 	// clientV3.WithPrefix()：按照前缀匹配
-	getResp, err := r.c.Get(ctx, r.serviceKey(serviceName), clientV3.WithPrefix())
+	getResp, err := r.c.Get(ctx, r.codec.ServicePrefix(serviceName), clientV3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
@@ -64,51 +228,124 @@ func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]regi
 }
 
 // Subscribe 说是订阅，其实类似心跳一样的机制
+// 每个 etcd 的事件都会转换成一个带有实例信息的 Event
+// 监听断开之后从上一次的版本继续监听；版本已经被压缩的时候发送 EventResync
+// Close 之后返回的 channel 会被关闭
 func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
-	ctx, cancel := context.WithCancel(context.Background())
 	r.mutex.Lock()
-	r.cancels = append(r.cancels, cancel)
-	r.mutex.Unlock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	w := watcher.New(r.ctx.Done())
+	go r.watch(r.codec.ServicePrefix(serviceName), w)
+	return w.Events(), nil
+}
+
+func (r *Registry) watch(key string, w *watcher.Watcher) {
+	// 0 表示从当前开始监听
+	var rev int64
+	for {
+		rev = r.watchOnce(key, rev, w)
+		select {
+		case <-time.After(retryInterval):
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// watchOnce 一直监听到出错为止，返回下一次开始监听的版本
+func (r *Registry) watchOnce(key string, rev int64, w *watcher.Watcher) int64 {
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
 	// WithRequireLeader：只有在集群中有leader的时候才去拿数据
 	ctx = clientV3.WithRequireLeader(ctx)
-	watchResp := r.c.Watch(ctx, r.serviceKey(serviceName), clientV3.WithPrefix())
-	res := make(chan registry.Event)
-	go func() {
-		for {
-			select {
-			case resp := <-watchResp:
-				if resp.Canceled {
-					return
-				}
-				if resp.Err() != nil {
-					return
-				}
-				for range resp.Events {
-					res <- registry.Event{}
-				}
-			case <-ctx.Done():
-				return
+	// WithPrevKV：删除的时候也能拿到实例原本的信息
+	opts := []clientV3.OpOption{clientV3.WithPrefix(), clientV3.WithPrevKV()}
+	if rev > 0 {
+		opts = append(opts, clientV3.WithRev(rev))
+	}
+	for resp := range r.c.Watch(ctx, key, opts...) {
+		if resp.CompactRevision != 0 {
+			return r.resync(key, w)
+		}
+		if err := resp.Err(); err != nil {
+			w.Push(registry.Event{Type: registry.EventError, Err: err})
+			return rev
+		}
+		for _, ev := range resp.Events {
+			e, err := decodeEvent(r.codec, ev)
+			if err != nil {
+				e = registry.Event{Type: registry.EventError, Err: err}
 			}
+			w.Push(e)
+			rev = ev.Kv.ModRevision + 1
 		}
-	}()
-	return res, nil
+		if resp.Header.Revision >= rev {
+			rev = resp.Header.Revision + 1
+		}
+	}
+	return rev
 }
 
-func (r *Registry) Close() error {
-	r.mutex.Lock()
-	cancels := r.cancels
-	r.cancels = nil
-	r.mutex.Unlock()
-	for _, cancel := range cancels {
-		cancel()
+// resync 需要的版本已经被压缩了，中间的变更已经丢失，通知订阅方重新获取全部实例
+// 先拿到当前的版本再通知，订阅方重新获取的结果不会比新的监听更旧
+func (r *Registry) resync(key string, w *watcher.Watcher) int64 {
+	ctx, cancel := context.WithTimeout(r.ctx, time.Second*3)
+	defer cancel()
+	resp, err := r.c.Get(ctx, key, clientV3.WithPrefix(), clientV3.WithCountOnly())
+	if err != nil {
+		w.Push(registry.Event{Type: registry.EventError, Err: err})
+		return 0
 	}
-	return r.sess.Close()
+	w.Push(registry.Event{Type: registry.EventResync})
+	return resp.Header.Revision + 1
 }
 
-func (r *Registry) instanceKey(si registry.ServiceInstance) string {
-	return fmt.Sprintf("/micro/%s/%s", si.Name, si.Address)
+// decodeEvent 把 etcd 的事件转换成 registry.Event
+func decodeEvent(codec KeyCodec, ev *clientV3.Event) (registry.Event, error) {
+	switch ev.Type {
+	case mvccpb.PUT:
+		var si registry.ServiceInstance
+		if err := json.Unmarshal(ev.Kv.Value, &si); err != nil {
+			return registry.Event{}, err
+		}
+		typ := registry.EventUpdate
+		if ev.IsCreate() {
+			typ = registry.EventAdd
+		}
+		return registry.Event{Type: typ, Instance: si}, nil
+	case mvccpb.DELETE:
+		// 删除的事件没有 value，优先使用删除之前的值
+		if ev.PrevKv != nil && len(ev.PrevKv.Value) > 0 {
+			var si registry.ServiceInstance
+			if err := json.Unmarshal(ev.PrevKv.Value, &si); err == nil {
+				return registry.Event{Type: registry.EventDelete, Instance: si}, nil
+			}
+		}
+		si, err := codec.Decode(string(ev.Kv.Key))
+		if err != nil {
+			return registry.Event{}, err
+		}
+		return registry.Event{Type: registry.EventDelete, Instance: si}, nil
+	default:
+		return registry.Event{}, fmt.Errorf("micro: 未知的 etcd 事件类型 %v", ev.Type)
+	}
 }
 
-func (r *Registry) serviceKey(serviceName string) string {
-	return fmt.Sprintf("/micro/%s", serviceName)
+// Close 释放租约，注册的实例会被删除，所有的订阅都会结束
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.closed = true
+	sess := r.sess
+	r.mutex.Unlock()
+	// 先释放租约，session 的 ctx 被取消之后就不能再撤销租约了
+	err := sess.Close()
+	r.cancel()
+	return err
 }
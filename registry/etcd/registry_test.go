@@ -0,0 +1,300 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientV3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/internal/watcher"
+	"web/micro/registry/registrytest"
+)
+
+// testEndpoints 测试用的 etcd，没有设置 MICRO_ETCD_ENDPOINTS 的时候启动一个内嵌的 etcd
+var testEndpoints []string
+
+func TestMain(m *testing.M) {
+	if endpoints := os.Getenv("MICRO_ETCD_ENDPOINTS"); endpoints != "" {
+		testEndpoints = strings.Split(endpoints, ",")
+		os.Exit(m.Run())
+	}
+	os.Exit(runEmbed(m))
+}
+
+func runEmbed(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "micro-etcd")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(), freeURL()
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer e.Close()
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		panic("内嵌的 etcd 启动超时")
+	}
+	testEndpoints = []string{clientURL.Host}
+	return m.Run()
+}
+
+// freeURL 找一个空闲的端口
+func freeURL() url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func TestRegistry_Conformance(t *testing.T) {
+	registrytest.Run(t, func(t *testing.T) registry.Registry {
+		r, err := NewRegistry(newTestClient(t))
+		require.NoError(t, err)
+		return r
+	})
+}
+
+// TestRegistry_LeaseLost 租约丢失之后，实例会被重新注册
+func TestRegistry_LeaseLost(t *testing.T) {
+	c := newTestClient(t)
+	// 续约的间隔是 TTL 的三分之一，TTL 短一点才能尽快发现租约丢失
+	r, err := NewRegistry(c, RegistryWithTTL(3))
+	require.NoError(t, err)
+	defer r.Close()
+	si := registry.ServiceInstance{Name: fmt.Sprintf("lease-%d", time.Now().UnixNano()), Address: "127.0.0.1:8081"}
+	require.NoError(t, r.Register(context.Background(), si))
+
+	r.mutex.Lock()
+	lease := r.sess.Lease()
+	r.mutex.Unlock()
+	_, err = c.Revoke(context.Background(), lease)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		ins, er := r.ListServices(context.Background(), si.Name)
+		return er == nil && len(ins) == 1
+	}, 10*time.Second, 100*time.Millisecond)
+	r.mutex.Lock()
+	assert.NotEqual(t, lease, r.sess.Lease())
+	r.mutex.Unlock()
+}
+
+// TestRegistry_WatchResume 监听断开之后从上一次的版本继续，版本被压缩了就发送 EventResync
+func TestRegistry_WatchResume(t *testing.T) {
+	c := newTestClient(t)
+	r, err := NewRegistry(c)
+	require.NoError(t, err)
+	defer r.Close()
+	name := fmt.Sprintf("resume-%d", time.Now().UnixNano())
+	codec := NewPathCodec("")
+	put := func(si registry.ServiceInstance) int64 {
+		val, er := json.Marshal(si)
+		require.NoError(t, er)
+		resp, er := c.Put(context.Background(), codec.InstanceKey(si), string(val))
+		require.NoError(t, er)
+		return resp.Header.Revision
+	}
+	first := registry.ServiceInstance{Name: name, Address: "127.0.0.1:8081"}
+	second := registry.ServiceInstance{Name: name, Address: "127.0.0.1:8082"}
+	rev := put(first)
+	put(second)
+
+	testCases := []struct {
+		name string
+		// 压缩到最新的版本
+		compact    bool
+		wantEvents []registry.Event
+	}{
+		{
+			name: "resume",
+			wantEvents: []registry.Event{
+				{Type: registry.EventAdd, Instance: first},
+				{Type: registry.EventAdd, Instance: second},
+			},
+		},
+		{
+			name:       "compacted",
+			compact:    true,
+			wantEvents: []registry.Event{{Type: registry.EventResync}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.compact {
+				latest := put(second)
+				_, err = c.Compact(context.Background(), latest)
+				require.NoError(t, err)
+			}
+			done := make(chan struct{})
+			defer close(done)
+			w := watcher.New(done)
+			// 模拟断开之后重新监听，从 rev 开始，r.Close 的时候退出
+			go r.watchOnce(r.codec.ServicePrefix(name), rev, w)
+			for _, want := range tc.wantEvents {
+				select {
+				case e := <-w.Events():
+					assert.Equal(t, want, e)
+				case <-time.After(5 * time.Second):
+					t.Fatal("没有收到事件")
+				}
+			}
+		})
+	}
+}
+
+func newTestClient(t *testing.T) *clientV3.Client {
+	c, err := clientV3.New(clientV3.Config{
+		Endpoints:   testEndpoints,
+		DialTimeout: 3 * time.Second,
+		Logger:      zap.NewNop(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestDecodeEvent(t *testing.T) {
+	testCases := []struct {
+		name      string
+		ev        *clientV3.Event
+		wantEvent registry.Event
+		wantErr   bool
+	}{
+		{
+			name: "add",
+			ev: &clientV3.Event{
+				Type: mvccpb.PUT,
+				Kv: &mvccpb.KeyValue{
+					Key:            []byte("/micro/user-service/127.0.0.1:8081"),
+					Value:          []byte(`{"Name":"user-service","Address":"127.0.0.1:8081","Weight":10}`),
+					CreateRevision: 3,
+					ModRevision:    3,
+				},
+			},
+			wantEvent: registry.Event{
+				Type:     registry.EventAdd,
+				Instance: registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10},
+			},
+		},
+		{
+			name: "add with metadata",
+			ev: &clientV3.Event{
+				Type: mvccpb.PUT,
+				Kv: &mvccpb.KeyValue{
+					Key: []byte("/micro/user-service/127.0.0.1:8081"),
+					Value: []byte(`{"Name":"user-service","Address":"127.0.0.1:8081","Version":"v1.0.0",` +
+						`"Group":"gray","Tags":["a"],"Metadata":{"zone":"sh"}}`),
+					CreateRevision: 3,
+					ModRevision:    3,
+				},
+			},
+			wantEvent: registry.Event{
+				Type: registry.EventAdd,
+				Instance: registry.ServiceInstance{
+					Name:     "user-service",
+					Address:  "127.0.0.1:8081",
+					Version:  "v1.0.0",
+					Group:    "gray",
+					Tags:     []string{"a"},
+					Metadata: map[string]string{"zone": "sh"},
+				},
+			},
+		},
+		{
+			name: "update",
+			ev: &clientV3.Event{
+				Type: mvccpb.PUT,
+				Kv: &mvccpb.KeyValue{
+					Key:            []byte("/micro/user-service/127.0.0.1:8081"),
+					Value:          []byte(`{"Name":"user-service","Address":"127.0.0.1:8081","Weight":20}`),
+					CreateRevision: 3,
+					ModRevision:    5,
+				},
+			},
+			wantEvent: registry.Event{
+				Type:     registry.EventUpdate,
+				Instance: registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 20},
+			},
+		},
+		{
+			name: "delete with prev kv",
+			ev: &clientV3.Event{
+				Type: mvccpb.DELETE,
+				Kv:   &mvccpb.KeyValue{Key: []byte("/micro/user-service/127.0.0.1:8081")},
+				PrevKv: &mvccpb.KeyValue{
+					Value: []byte(`{"Name":"user-service","Address":"127.0.0.1:8081","Weight":20}`),
+				},
+			},
+			wantEvent: registry.Event{
+				Type:     registry.EventDelete,
+				Instance: registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 20},
+			},
+		},
+		{
+			name: "delete from key",
+			ev: &clientV3.Event{
+				Type: mvccpb.DELETE,
+				Kv:   &mvccpb.KeyValue{Key: []byte("/micro/user-service/127.0.0.1:8081")},
+			},
+			wantEvent: registry.Event{
+				Type:     registry.EventDelete,
+				Instance: registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"},
+			},
+		},
+		{
+			name: "invalid key",
+			ev: &clientV3.Event{
+				Type: mvccpb.DELETE,
+				Kv:   &mvccpb.KeyValue{Key: []byte("/micro/user-service")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid value",
+			ev: &clientV3.Event{
+				Type: mvccpb.PUT,
+				Kv:   &mvccpb.KeyValue{Key: []byte("/micro/user-service/127.0.0.1:8081"), Value: []byte("abc")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := decodeEvent(NewPathCodec(""), tc.ev)
+			assert.Equal(t, tc.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantEvent, e)
+		})
+	}
+}
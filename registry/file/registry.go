@@ -0,0 +1,370 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/internal/watcher"
+)
+
+var ErrRegistryClosed = errors.New("micro: 注册中心已经关闭")
+
+// Registry 从 YAML 或者 JSON 文件里面读取服务实例，文件的格式由后缀名决定
+//
+//	services:
+//	  user-service:
+//	    - address: 127.0.0.1:8081
+//	      weight: 10
+//
+// 定时检查文件的修改时间和大小，文件变化之后通知订阅方
+type Registry struct {
+	path     string
+	interval time.Duration
+	// 为 false 的时候 Register 和 UnRegister 什么都不做
+	writeBack bool
+
+	mutex sync.Mutex
+	// 服务名 -> 地址 -> 实例
+	services map[string]map[string]registry.ServiceInstance
+	modTime  time.Time
+	size     int64
+	// 上一次重新读取失败的原因，状态变化的时候才通知订阅方
+	lastErr  string
+	watchers map[string][]*watcher.Watcher
+	closed   bool
+	closeCh  chan struct{}
+}
+
+type RegistryOption func(*Registry)
+
+// RegistryWithInterval 设置检查文件变化的间隔，默认是一秒
+func RegistryWithInterval(interval time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+// RegistryWithWriteBack Register 和 UnRegister 的时候把结果写回文件
+// 写入的时候先写临时文件再重命名，读取方不会读到写了一半的文件
+func RegistryWithWriteBack() RegistryOption {
+	return func(r *Registry) {
+		r.writeBack = true
+	}
+}
+
+// NewRegistry 开启写回的时候，文件不存在会当作空的注册中心
+func NewRegistry(path string, opts ...RegistryOption) (*Registry, error) {
+	res := &Registry{
+		path:     path,
+		interval: time.Second,
+		services: make(map[string]map[string]registry.ServiceInstance, 8),
+		watchers: make(map[string][]*watcher.Watcher, 8),
+		closeCh:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if err := res.reload(); err != nil {
+		return nil, err
+	}
+	go res.pollLoop()
+	return res, nil
+}
+
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	return r.update(func(services map[string]map[string]registry.ServiceInstance) {
+		ins, ok := services[si.Name]
+		if !ok {
+			ins = make(map[string]registry.ServiceInstance, 4)
+			services[si.Name] = ins
+		}
+		ins[si.Address] = si
+	})
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	return r.update(func(services map[string]map[string]registry.ServiceInstance) {
+		delete(services[si.Name], si.Address)
+	})
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	ins := r.services[serviceName]
+	res := make([]registry.ServiceInstance, 0, len(ins))
+	for _, si := range ins {
+		res = append(res, si)
+	}
+	return res, nil
+}
+
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	w := watcher.New(r.closeCh)
+	r.watchers[serviceName] = append(r.watchers[serviceName], w)
+	return w.Events(), nil
+}
+
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.closeCh)
+	return nil
+}
+
+// update 先读取文件里面最新的内容再修改，避免覆盖掉别人的修改
+func (r *Registry) update(fn func(services map[string]map[string]registry.ServiceInstance)) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrRegistryClosed
+	}
+	if !r.writeBack {
+		return nil
+	}
+	services, _, err := r.read(nil)
+	if err != nil {
+		return err
+	}
+	fn(services)
+	if err = r.write(services); err != nil {
+		return err
+	}
+	// 刚刚写入的文件一定要重新读取
+	r.modTime = time.Time{}
+	return r.reloadLocked()
+}
+
+func (r *Registry) pollLoop() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mutex.Lock()
+			r.poll()
+			r.mutex.Unlock()
+		case <-r.closeCh:
+			return
+		}
+	}
+}
+
+// poll 需要持有锁
+// 文件可能正在被编辑，出错的时候继续使用原来的内容，并且告诉订阅方
+// 文件一直缺失或者一直有问题的时候只通知一次
+func (r *Registry) poll() {
+	err := r.reloadLocked()
+	if err == nil {
+		r.lastErr = ""
+		return
+	}
+	if err.Error() == r.lastErr {
+		return
+	}
+	r.lastErr = err.Error()
+	for _, ws := range r.watchers {
+		for _, w := range ws {
+			w.Push(registry.Event{Type: registry.EventError, Err: err})
+		}
+	}
+}
+
+func (r *Registry) reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.reloadLocked()
+}
+
+// reloadLocked 文件变化了才重新读取，和原来的内容比较之后通知订阅方
+func (r *Registry) reloadLocked() error {
+	services, info, err := r.read(func(info os.FileInfo) bool {
+		return info.ModTime().Equal(r.modTime) && info.Size() == r.size
+	})
+	if err != nil || services == nil {
+		return err
+	}
+	if info != nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+	for name, ins := range services {
+		old := r.services[name]
+		for addr, si := range ins {
+			oldSi, ok := old[addr]
+			switch {
+			case !ok:
+				r.notify(registry.Event{Type: registry.EventAdd, Instance: si})
+			case !reflect.DeepEqual(oldSi, si):
+				r.notify(registry.Event{Type: registry.EventUpdate, Instance: si})
+			}
+		}
+	}
+	for name, ins := range r.services {
+		for addr, si := range ins {
+			if _, ok := services[name][addr]; !ok {
+				r.notify(registry.Event{Type: registry.EventDelete, Instance: si})
+			}
+		}
+	}
+	r.services = services
+	return nil
+}
+
+// notify 需要持有锁
+func (r *Registry) notify(e registry.Event) {
+	for _, w := range r.watchers[e.Instance.Name] {
+		w.Push(e)
+	}
+}
+
+// read 读取并解析文件，开启写回并且文件不存在的时候返回空的内容
+// 打开之后先 Stat 再读取，拿到的信息和内容是同一个文件的；unchanged 返回 true 的时候不再读取，返回 nil
+func (r *Registry) read(unchanged func(info os.FileInfo) bool) (map[string]map[string]registry.ServiceInstance, os.FileInfo, error) {
+	res := make(map[string]map[string]registry.ServiceInstance, 8)
+	f, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) && r.writeBack {
+		return res, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if unchanged != nil && unchanged(info) {
+		return nil, info, nil
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	var content fileContent
+	if r.isYAML() {
+		err = yaml.Unmarshal(data, &content)
+	} else if len(data) > 0 {
+		err = json.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("micro: 解析文件 %s 失败: %w", r.path, err)
+	}
+	for name, ins := range content.Services {
+		m := make(map[string]registry.ServiceInstance, len(ins))
+		for _, in := range ins {
+			m[in.Address] = in.toServiceInstance(name)
+		}
+		if len(m) > 0 {
+			res[name] = m
+		}
+	}
+	return res, info, nil
+}
+
+// write 先写到同一个目录下的临时文件，再重命名覆盖原来的文件
+func (r *Registry) write(services map[string]map[string]registry.ServiceInstance) error {
+	content := fileContent{Services: make(map[string][]instance, len(services))}
+	for name, ins := range services {
+		if len(ins) == 0 {
+			continue
+		}
+		list := make([]instance, 0, len(ins))
+		for _, si := range ins {
+			list = append(list, newInstance(si))
+		}
+		content.Services[name] = list
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if r.isYAML() {
+		data, err = yaml.Marshal(content)
+	} else {
+		data, err = json.MarshalIndent(content, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if er := tmp.Close(); err == nil {
+		err = er
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+func (r *Registry) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(r.path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+type fileContent struct {
+	Services map[string][]instance `json:"services" yaml:"services"`
+}
+
+// instance 文件里面的实例，服务名是外层的 key
+type instance struct {
+	Address  string            `json:"address" yaml:"address"`
+	Weight   uint32            `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Version  string            `json:"version,omitempty" yaml:"version,omitempty"`
+	Group    string            `json:"group,omitempty" yaml:"group,omitempty"`
+	Tags     []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+func newInstance(si registry.ServiceInstance) instance {
+	return instance{
+		Address:  si.Address,
+		Weight:   si.Weight,
+		Version:  si.Version,
+		Group:    si.Group,
+		Tags:     si.Tags,
+		Metadata: si.Metadata,
+	}
+}
+
+func (i instance) toServiceInstance(name string) registry.ServiceInstance {
+	return registry.ServiceInstance{
+		Name:     name,
+		Address:  i.Address,
+		Weight:   i.Weight,
+		Version:  i.Version,
+		Group:    i.Group,
+		Tags:     i.Tags,
+		Metadata: i.Metadata,
+	}
+}
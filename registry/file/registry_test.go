@@ -0,0 +1,196 @@
+package file
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/registrytest"
+)
+
+func TestRegistry_Conformance(t *testing.T) {
+	for _, name := range []string{"registry.json", "registry.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			registrytest.Run(t, func(t *testing.T) registry.Registry {
+				r, err := NewRegistry(filepath.Join(t.TempDir(), name), RegistryWithWriteBack())
+				require.NoError(t, err)
+				return r
+			})
+		})
+	}
+}
+
+func TestRegistry_Load(t *testing.T) {
+	testCases := []struct {
+		name    string
+		file    string
+		content string
+		wantIns []registry.ServiceInstance
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			file: "registry.yaml",
+			content: `
+services:
+  user-service:
+    - address: 127.0.0.1:8081
+      weight: 10
+      group: gray
+      tags: [a]
+      metadata:
+        zone: sh
+`,
+			wantIns: []registry.ServiceInstance{
+				{
+					Name:     "user-service",
+					Address:  "127.0.0.1:8081",
+					Weight:   10,
+					Group:    "gray",
+					Tags:     []string{"a"},
+					Metadata: map[string]string{"zone": "sh"},
+				},
+			},
+		},
+		{
+			name:    "json",
+			file:    "registry.json",
+			content: `{"services":{"user-service":[{"address":"127.0.0.1:8081","version":"v1"}]}}`,
+			wantIns: []registry.ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Version: "v1"},
+			},
+		},
+		{
+			name:    "invalid",
+			file:    "registry.json",
+			content: `{"services":`,
+			wantErr: true,
+		},
+		{
+			name:    "not exist",
+			file:    "registry.json",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			if tc.content != "" {
+				require.NoError(t, os.WriteFile(path, []byte(tc.content), 0644))
+			}
+			r, err := NewRegistry(path)
+			assert.Equal(t, tc.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			defer r.Close()
+			ins, err := r.ListServices(context.Background(), "user-service")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantIns, ins)
+		})
+	}
+}
+
+func TestRegistry_HotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+services:
+  user-service:
+    - address: 127.0.0.1:8081
+`), 0644))
+	r, err := NewRegistry(path, RegistryWithInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+services:
+  user-service:
+    - address: 127.0.0.1:8082
+`), 0644))
+	got := map[registry.EventType]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			got[e.Type] = e.Instance.Address
+		case <-time.After(time.Second):
+			t.Fatal("没有收到事件")
+		}
+	}
+	assert.Equal(t, map[registry.EventType]string{
+		registry.EventAdd:    "127.0.0.1:8082",
+		registry.EventDelete: "127.0.0.1:8081",
+	}, got)
+
+	// 只读的时候不修改文件
+	require.NoError(t, r.Register(context.Background(), registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8083"}))
+	ins, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{{Name: "user-service", Address: "127.0.0.1:8082"}}, ins)
+}
+
+// TestRegistry_ErrorOnce 文件一直缺失或者一直有问题的时候，只通知一次
+func TestRegistry_ErrorOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("services: {}"), 0644))
+	r, err := NewRegistry(path, RegistryWithInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer r.Close()
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		change   func()
+		wantType registry.EventType
+	}{
+		{
+			name: "missing",
+			change: func() {
+				require.NoError(t, os.Remove(path))
+			},
+			wantType: registry.EventError,
+		},
+		{
+			name: "broken",
+			change: func() {
+				require.NoError(t, os.WriteFile(path, []byte("services: ["), 0644))
+			},
+			wantType: registry.EventError,
+		},
+		{
+			name: "recovered",
+			change: func() {
+				require.NoError(t, os.WriteFile(path, []byte(`
+services:
+  user-service:
+    - address: 127.0.0.1:8081
+`), 0644))
+			},
+			wantType: registry.EventAdd,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.change()
+			select {
+			case e := <-events:
+				assert.Equal(t, tc.wantType, e.Type)
+			case <-time.After(time.Second):
+				t.Fatal("没有收到事件")
+			}
+			// 检查了很多次，也不会再有新的事件
+			select {
+			case e := <-events:
+				t.Fatalf("多余的事件 %v", e)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
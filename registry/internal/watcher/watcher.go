@@ -0,0 +1,72 @@
+// Package watcher 注册中心的实现共用的订阅方
+package watcher
+
+import (
+	"sync"
+	"web/micro/registry"
+)
+
+// Watcher 一个订阅方，事件先放进队列，再由单独的协程发送出去
+// 订阅方不及时读取也不会阻塞注册中心
+type Watcher struct {
+	out    chan registry.Event
+	mutex  sync.Mutex
+	events []registry.Event
+	notify chan struct{}
+	done   <-chan struct{}
+}
+
+// New done 关闭之后，Events 返回的 channel 也会被关闭
+func New(done <-chan struct{}) *Watcher {
+	res := &Watcher{
+		out:    make(chan registry.Event),
+		notify: make(chan struct{}, 1),
+		done:   done,
+	}
+	go res.run()
+	return res
+}
+
+func (w *Watcher) Events() <-chan registry.Event {
+	return w.out
+}
+
+// Push 订阅结束之后的事件直接丢弃
+func (w *Watcher) Push(e registry.Event) {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	w.mutex.Lock()
+	w.events = append(w.events, e)
+	w.mutex.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) run() {
+	defer close(w.out)
+	for {
+		w.mutex.Lock()
+		if len(w.events) == 0 {
+			w.mutex.Unlock()
+			select {
+			case <-w.notify:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+		e := w.events[0]
+		w.events = w.events[1:]
+		w.mutex.Unlock()
+		select {
+		case w.out <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
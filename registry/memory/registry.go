@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/internal/watcher"
+)
+
+var ErrRegistryClosed = errors.New("micro: 注册中心已经关闭")
+
+// Registry 保存在内存里面的注册中心，用于测试和单进程部署
+// 设置了 TTL 的时候，实例需要在 TTL 之内重新 Register 续约，否则会被删除
+// micro.Server 通过 ServerWithRegisterRefresh 定时重新注册
+type Registry struct {
+	mutex sync.Mutex
+	// 服务名 -> 地址 -> 实例
+	services map[string]map[string]*entry
+	watchers map[string][]*watcher.Watcher
+	ttl      time.Duration
+	closed   bool
+	closeCh  chan struct{}
+}
+
+type entry struct {
+	si registry.ServiceInstance
+	// 零值表示永不过期
+	expireAt time.Time
+}
+
+type RegistryOption func(*Registry)
+
+// RegistryWithTTL 设置实例的过期时间，默认不过期
+func RegistryWithTTL(ttl time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+func NewRegistry(opts ...RegistryOption) *Registry {
+	res := &Registry{
+		services: make(map[string]map[string]*entry, 8),
+		watchers: make(map[string][]*watcher.Watcher, 8),
+		closeCh:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if res.ttl > 0 {
+		go res.expireLoop()
+	}
+	return res
+}
+
+// Register 重复注册同一个地址的实例会刷新过期时间，实例信息变化的时候通知 Update
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrRegistryClosed
+	}
+	ins, ok := r.services[si.Name]
+	if !ok {
+		ins = make(map[string]*entry, 4)
+		r.services[si.Name] = ins
+	}
+	var expireAt time.Time
+	if r.ttl > 0 {
+		expireAt = time.Now().Add(r.ttl)
+	}
+	old, ok := ins[si.Address]
+	ins[si.Address] = &entry{si: si, expireAt: expireAt}
+	switch {
+	case !ok:
+		r.notify(registry.Event{Type: registry.EventAdd, Instance: si})
+	case !reflect.DeepEqual(old.si, si):
+		r.notify(registry.Event{Type: registry.EventUpdate, Instance: si})
+	}
+	return nil
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrRegistryClosed
+	}
+	r.remove(si.Name, si.Address)
+	return nil
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	now := time.Now()
+	ins := r.services[serviceName]
+	res := make([]registry.ServiceInstance, 0, len(ins))
+	for _, e := range ins {
+		// 还没有被清理掉的过期实例也不返回
+		if e.expired(now) {
+			continue
+		}
+		res = append(res, e.si)
+	}
+	return res, nil
+}
+
+// Subscribe 返回的 channel 在 Close 的时候关闭
+// 每个订阅方有自己的队列，不及时读取也不会阻塞注册中心
+func (r *Registry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	w := watcher.New(r.closeCh)
+	r.watchers[serviceName] = append(r.watchers[serviceName], w)
+	return w.Events(), nil
+}
+
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.closeCh)
+	// 所有的订阅都结束了，不再需要通知
+	r.watchers = nil
+	return nil
+}
+
+// remove 需要持有锁
+func (r *Registry) remove(serviceName, addr string) {
+	ins := r.services[serviceName]
+	e, ok := ins[addr]
+	if !ok {
+		return
+	}
+	delete(ins, addr)
+	if len(ins) == 0 {
+		delete(r.services, serviceName)
+	}
+	r.notify(registry.Event{Type: registry.EventDelete, Instance: e.si})
+}
+
+// notify 需要持有锁
+func (r *Registry) notify(e registry.Event) {
+	for _, w := range r.watchers[e.Instance.Name] {
+		w.Push(e)
+	}
+}
+
+// expireLoop 定时清理过期的实例
+func (r *Registry) expireLoop() {
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.mutex.Lock()
+			for name, ins := range r.services {
+				for addr, e := range ins {
+					if e.expired(now) {
+						r.remove(name, addr)
+					}
+				}
+			}
+			r.mutex.Unlock()
+		case <-r.closeCh:
+			return
+		}
+	}
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
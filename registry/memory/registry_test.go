@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/registrytest"
+)
+
+func TestRegistry_Conformance(t *testing.T) {
+	registrytest.Run(t, func(t *testing.T) registry.Registry {
+		return NewRegistry()
+	})
+}
+
+func TestRegistry_TTL(t *testing.T) {
+	r := NewRegistry(RegistryWithTTL(50 * time.Millisecond))
+	defer r.Close()
+	ctx := context.Background()
+	events, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+	kept := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	expired := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082"}
+	require.NoError(t, r.Register(ctx, kept))
+	require.NoError(t, r.Register(ctx, expired))
+	assert.Equal(t, registry.EventAdd, (<-events).Type)
+	assert.Equal(t, registry.EventAdd, (<-events).Type)
+
+	// kept 一直续约，expired 不续约
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		require.NoError(t, r.Register(ctx, kept))
+		time.Sleep(10 * time.Millisecond)
+	}
+	ins, err := r.ListServices(ctx, "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{kept}, ins)
+	e := <-events
+	assert.Equal(t, registry.Event{Type: registry.EventDelete, Instance: expired}, e)
+}
+
+func TestRegistry_Closed(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.Subscribe("user-service")
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	// 订阅都结束了，不再保留订阅方
+	assert.Empty(t, r.watchers)
+	ctx := context.Background()
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	assert.Equal(t, ErrRegistryClosed, r.Register(ctx, si))
+	assert.Equal(t, ErrRegistryClosed, r.UnRegister(ctx, si))
+	_, err = r.ListServices(ctx, "user-service")
+	assert.Equal(t, ErrRegistryClosed, err)
+	_, err = r.Subscribe("user-service")
+	assert.Equal(t, ErrRegistryClosed, err)
+}
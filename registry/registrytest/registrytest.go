@@ -0,0 +1,151 @@
+// Package registrytest 注册中心的一致性测试，每个 registry.Registry 的实现都应该跑一遍
+package registrytest
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web/micro/registry"
+)
+
+// eventTimeout 等待订阅事件的时间
+const eventTimeout = 5 * time.Second
+
+var seq int64
+
+// Run 跑一遍所有的用例，newRegistry 每次都要返回一个新的注册中心
+// 不同的用例使用不同的服务名，可以共用同一个外部的注册中心
+func Run(t *testing.T, newRegistry func(t *testing.T) registry.Registry) {
+	testCases := []struct {
+		name string
+		fn   func(t *testing.T, r registry.Registry, serviceName string)
+	}{
+		{name: "register and list", fn: testRegisterAndList},
+		{name: "unregister", fn: testUnRegister},
+		{name: "list unknown service", fn: testListUnknown},
+		{name: "subscribe", fn: testSubscribe},
+		{name: "subscribe other service", fn: testSubscribeOther},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRegistry(t)
+			defer func() {
+				_ = r.Close()
+			}()
+			tc.fn(t, r, fmt.Sprintf("conformance-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&seq, 1)))
+		})
+	}
+
+	t.Run("close subscription", func(t *testing.T) {
+		r := newRegistry(t)
+		events, err := r.Subscribe(fmt.Sprintf("conformance-%d", atomic.AddInt64(&seq, 1)))
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		// Close 之后，订阅的 channel 要被关闭，里面可能还有没读完的事件
+		timer := time.NewTimer(eventTimeout)
+		defer timer.Stop()
+		for {
+			select {
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+			case <-timer.C:
+				t.Fatal("Close 之后订阅没有结束")
+			}
+		}
+	})
+}
+
+func instance(serviceName, addr string) registry.ServiceInstance {
+	return registry.ServiceInstance{
+		Name:     serviceName,
+		Address:  addr,
+		Weight:   10,
+		Version:  "v1.0.0",
+		Group:    "gray",
+		Tags:     []string{"a", "b"},
+		Metadata: map[string]string{"zone": "sh"},
+	}
+}
+
+func testRegisterAndList(t *testing.T, r registry.Registry, serviceName string) {
+	ctx := context.Background()
+	a := instance(serviceName, "127.0.0.1:8081")
+	b := instance(serviceName, "127.0.0.1:8082")
+	require.NoError(t, r.Register(ctx, a))
+	require.NoError(t, r.Register(ctx, b))
+	ins, err := r.ListServices(ctx, serviceName)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []registry.ServiceInstance{a, b}, ins)
+}
+
+func testUnRegister(t *testing.T, r registry.Registry, serviceName string) {
+	ctx := context.Background()
+	a := instance(serviceName, "127.0.0.1:8081")
+	b := instance(serviceName, "127.0.0.1:8082")
+	require.NoError(t, r.Register(ctx, a))
+	require.NoError(t, r.Register(ctx, b))
+	require.NoError(t, r.UnRegister(ctx, a))
+	ins, err := r.ListServices(ctx, serviceName)
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{b}, ins)
+}
+
+func testListUnknown(t *testing.T, r registry.Registry, serviceName string) {
+	ins, err := r.ListServices(context.Background(), serviceName)
+	require.NoError(t, err)
+	assert.Empty(t, ins)
+}
+
+func testSubscribe(t *testing.T, r registry.Registry, serviceName string) {
+	ctx := context.Background()
+	events, err := r.Subscribe(serviceName)
+	require.NoError(t, err)
+
+	si := instance(serviceName, "127.0.0.1:8081")
+	require.NoError(t, r.Register(ctx, si))
+	e := nextEvent(t, events)
+	assert.Equal(t, registry.EventAdd, e.Type)
+	assert.Equal(t, si, e.Instance)
+
+	si.Weight = 20
+	require.NoError(t, r.Register(ctx, si))
+	e = nextEvent(t, events)
+	assert.Equal(t, registry.EventUpdate, e.Type)
+	assert.Equal(t, si, e.Instance)
+
+	require.NoError(t, r.UnRegister(ctx, si))
+	e = nextEvent(t, events)
+	assert.Equal(t, registry.EventDelete, e.Type)
+	// 删除的时候至少要知道是哪个实例
+	assert.Equal(t, si.Name, e.Instance.Name)
+	assert.Equal(t, si.Address, e.Instance.Address)
+}
+
+func testSubscribeOther(t *testing.T, r registry.Registry, serviceName string) {
+	ctx := context.Background()
+	events, err := r.Subscribe(serviceName)
+	require.NoError(t, err)
+	require.NoError(t, r.Register(ctx, instance(serviceName+"-other", "127.0.0.1:8081")))
+	require.NoError(t, r.Register(ctx, instance(serviceName, "127.0.0.1:8082")))
+	// 第一个事件就应该是订阅的服务的
+	e := nextEvent(t, events)
+	assert.Equal(t, serviceName, e.Instance.Name)
+	assert.Equal(t, "127.0.0.1:8082", e.Instance.Address)
+}
+
+func nextEvent(t *testing.T, events <-chan registry.Event) registry.Event {
+	select {
+	case e, ok := <-events:
+		require.True(t, ok, "订阅已经结束")
+		return e
+	case <-time.After(eventTimeout):
+		t.Fatal("没有收到事件")
+		return registry.Event{}
+	}
+}
@@ -16,8 +16,54 @@ type Registry interface {
 type ServiceInstance struct {
 	Name    string
 	Address string
+	// Weight 权重，负载均衡的时候使用，没有设置的时候当作 1
+	Weight uint32
+	// Version 服务的版本，例如 v1.2.0
+	Version string
+	// Group 分组，例如机房、环境，可以用来做路由
+	Group string
+	Tags  []string
+	// Metadata 其余的信息，例如协议、可用区
+	Metadata map[string]string
 }
 
+// EventType 注册中心变更的类型
+type EventType uint8
+
+const (
+	// EventAdd 新的实例上线
+	EventAdd EventType = iota + 1
+	// EventUpdate 实例的信息发生了变化
+	EventUpdate
+	// EventDelete 实例下线，Instance 里面至少有 Name 和 Address
+	EventDelete
+	// EventError 订阅出错了，错误在 Err 里面
+	EventError
+	// EventResync 增量的变更不可信了，订阅方应该重新获取全部实例
+	EventResync
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdd:
+		return "Add"
+	case EventUpdate:
+		return "Update"
+	case EventDelete:
+		return "Delete"
+	case EventError:
+		return "Error"
+	case EventResync:
+		return "Resync"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event 订阅收到的变更，订阅方可以据此增量地更新本地的实例列表
 type Event struct {
-	Type string
+	Type EventType
+	// 发生变化的实例，Error 和 Resync 的时候为空
+	Instance ServiceInstance
+	Err      error
 }
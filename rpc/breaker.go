@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"errors"
+	"strings"
+	"web/micro/circuitbreaker"
+	"web/micro/registry"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/loadbalance"
+	"web/micro/rpc/message"
+	"web/micro/rpc/status"
+)
+
+// ClientWithCircuitBreaker 每个实例一个熔断器，熔断的实例不会被负载均衡选中
+// 所有的实例都熔断了的时候返回 Unavailable，同时 errors.Is(err, circuitbreaker.ErrCircuitOpen) 成立
+// 默认只有 Unavailable、DeadlineExceeded 这类说明下游出问题的错误码才算失败，可以用 circuitbreaker.WithIsFailure 覆盖
+func ClientWithCircuitBreaker(opts ...circuitbreaker.Option) ClientOption {
+	return func(c *Client) {
+		opts = append([]circuitbreaker.Option{circuitbreaker.WithIsFailure(isBreakerFailure)}, opts...)
+		c.breakers = circuitbreaker.NewGroup(opts...)
+	}
+}
+
+// BreakerScope 决定同一个实例上哪些调用共用一个熔断器，返回空字符串表示整个实例共用一个
+type BreakerScope func(req *message.Request) string
+
+// BreakerPerInstance 每个实例一个熔断器，默认的范围
+func BreakerPerInstance(req *message.Request) string {
+	return ""
+}
+
+// BreakerPerMethod 每个实例上的每个方法一个熔断器，一个方法出问题不影响同一个实例上的其他方法
+func BreakerPerMethod(req *message.Request) string {
+	return req.ServiceName + "/" + req.MethodName
+}
+
+// ClientWithBreakerScope 设置熔断器的范围，要和 ClientWithCircuitBreaker 一起使用
+func ClientWithBreakerScope(scope BreakerScope) ClientOption {
+	return func(c *Client) {
+		c.breakerScope = scope
+	}
+}
+
+// errCircuitOpen 熔断的时候返回，服务端的方法里面直接返回给上游也能带上错误码
+var errCircuitOpen error = circuitOpenError{s: status.New(codes.Unavailable, circuitbreaker.ErrCircuitOpen.Error())}
+
+// circuitOpenError 既能被 status.Code 识别，也能用 errors.Is 判断
+type circuitOpenError struct {
+	s *status.Status
+}
+
+func (e circuitOpenError) Error() string {
+	return e.s.Error()
+}
+
+func (e circuitOpenError) Unwrap() []error {
+	return []error{e.s, circuitbreaker.ErrCircuitOpen}
+}
+
+// isBreakerFailure 业务错误说明下游是好的，不算失败
+func isBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// breakerKey 熔断器的 key 总是以实例地址开头
+func (c *Client) breakerKey(addr string, req *message.Request) string {
+	if c.breakerScope == nil {
+		return addr
+	}
+	if scope := c.breakerScope(req); scope != "" {
+		return addr + "/" + scope
+	}
+	return addr
+}
+
+// removeBreakers 实例下线之后，删掉这个实例的所有熔断器
+func (c *Client) removeBreakers(addr string) {
+	if c.breakers == nil {
+		return
+	}
+	prefix := addr + "/"
+	c.breakers.RemoveIf(func(key string) bool {
+		return key == addr || strings.HasPrefix(key, prefix)
+	})
+}
+
+// allow 没有配置熔断器的时候总是放行
+func (c *Client) allow(addr string, req *message.Request) (loadbalance.DoneFunc, error) {
+	if c.breakers == nil {
+		return func(err error) {}, nil
+	}
+	done, err := c.breakers.Get(c.breakerKey(addr, req)).Allow()
+	if errors.Is(err, circuitbreaker.ErrCircuitOpen) {
+		return nil, errCircuitOpen
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadbalance.DoneFunc(done), nil
+}
+
+// available 去掉这次调用已经熔断的实例
+func (c *Client) available(instances []registry.ServiceInstance, req *message.Request) []registry.ServiceInstance {
+	if c.breakers == nil {
+		return instances
+	}
+	res := make([]registry.ServiceInstance, 0, len(instances))
+	for _, ins := range instances {
+		if c.breakers.Get(c.breakerKey(ins.Address, req)).State() != circuitbreaker.StateOpen {
+			res = append(res, ins)
+		}
+	}
+	return res
+}
+
+func without(instances []registry.ServiceInstance, addr string) []registry.ServiceInstance {
+	res := make([]registry.ServiceInstance, 0, len(instances))
+	for _, ins := range instances {
+		if ins.Address != addr {
+			res = append(res, ins)
+		}
+	}
+	return res
+}
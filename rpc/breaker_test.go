@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web/micro/circuitbreaker"
+	"web/micro/registry"
+	"web/micro/registry/memory"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	opts := []circuitbreaker.Option{
+		circuitbreaker.WithMinRequests(2),
+		circuitbreaker.WithOpenTimeout(time.Minute),
+	}
+
+	t.Run("direct", func(t *testing.T) {
+		bad := &flakyService{failures: 100}
+		server := NewServer()
+		require.NoError(t, server.RegisterService(bad))
+		client, err := NewClient(startTestServer(t, server), ClientWithCircuitBreaker(opts...))
+		require.NoError(t, err)
+		defer client.Close()
+		svc := &FlakyService{}
+		require.NoError(t, client.InitService(svc))
+		for i := 0; i < 2; i++ {
+			_, err = svc.GetById(context.Background(), &GetByIdReq{})
+			assert.Error(t, err)
+		}
+		_, err = svc.GetById(context.Background(), &GetByIdReq{})
+		assert.True(t, errors.Is(err, circuitbreaker.ErrCircuitOpen))
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&bad.calls))
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		r := memory.NewRegistry()
+		defer r.Close()
+		bad, good := &flakyService{failures: 100}, &flakyService{}
+		var badIns registry.ServiceInstance
+		for _, s := range []*flakyService{bad, good} {
+			server := NewServer()
+			require.NoError(t, server.RegisterService(s))
+			si := registry.ServiceInstance{
+				Name:    "flaky-service",
+				Address: startTestServer(t, server),
+			}
+			require.NoError(t, r.Register(context.Background(), si))
+			if s == bad {
+				badIns = si
+			}
+		}
+		client, err := NewClientWithRegistry("flaky-service", r, ClientWithCircuitBreaker(opts...))
+		require.NoError(t, err)
+		defer client.Close()
+		svc := &FlakyService{}
+		require.NoError(t, client.InitService(svc))
+		for i := 0; i < 10; i++ {
+			_, _ = svc.GetById(context.Background(), &GetByIdReq{})
+		}
+		// 坏的实例熔断之后，流量都到了好的实例上
+		assert.Equal(t, int32(2), atomic.LoadInt32(&bad.calls))
+		assert.Equal(t, int32(8), atomic.LoadInt32(&good.calls))
+
+		// 实例下线之后，它的熔断器也被删掉了
+		require.NoError(t, r.UnRegister(context.Background(), badIns))
+		require.Eventually(t, func() bool {
+			return client.breakers.Get(badIns.Address).State() != circuitbreaker.StateOpen
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+// TestClient_BreakerScope 按方法熔断的时候，同一个实例上的其他方法不受影响
+func TestClient_BreakerScope(t *testing.T) {
+	opts := []circuitbreaker.Option{
+		circuitbreaker.WithMinRequests(2),
+		circuitbreaker.WithOpenTimeout(time.Minute),
+	}
+	testCases := []struct {
+		name  string
+		scope BreakerScope
+		// 坏的方法熔断之后，好的方法是不是也熔断了
+		wantOpen bool
+	}{
+		{
+			name:     "per instance",
+			scope:    BreakerPerInstance,
+			wantOpen: true,
+		},
+		{
+			name:  "per method",
+			scope: BreakerPerMethod,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer()
+			require.NoError(t, server.RegisterService(&partialService{}))
+			client, err := NewClient(startTestServer(t, server),
+				ClientWithCircuitBreaker(opts...), ClientWithBreakerScope(tc.scope))
+			require.NoError(t, err)
+			defer client.Close()
+			svc := &PartialService{}
+			require.NoError(t, client.InitService(svc))
+			for i := 0; i < 2; i++ {
+				_, err = svc.Bad(context.Background(), &GetByIdReq{})
+				assert.Equal(t, codes.Unavailable, status.Code(err))
+			}
+			_, err = svc.Bad(context.Background(), &GetByIdReq{})
+			assert.True(t, errors.Is(err, circuitbreaker.ErrCircuitOpen))
+			_, err = svc.Good(context.Background(), &GetByIdReq{})
+			if tc.wantOpen {
+				assert.True(t, errors.Is(err, circuitbreaker.ErrCircuitOpen))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+type PartialService struct {
+	Bad  func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+	Good func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+}
+
+func (p *PartialService) Name() string {
+	return "partial-service"
+}
+
+// partialService Bad 总是返回 Unavailable，Good 总是成功
+type partialService struct{}
+
+func (p *partialService) Name() string {
+	return "partial-service"
+}
+
+func (p *partialService) Bad(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	return nil, status.Error(codes.Unavailable, "服务暂时不可用")
+}
+
+func (p *partialService) Good(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	return &GetByIdResp{Msg: "ok"}, nil
+}
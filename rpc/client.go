@@ -3,17 +3,26 @@ package rpc
 import (
 	"context"
 	"errors"
-	"github.com/silenceper/pool"
-	"net"
+	"fmt"
 	"reflect"
-	"strconv"
-	"time"
+	"sync/atomic"
+	"web/micro/circuitbreaker"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/compress"
+	"web/micro/rpc/loadbalance"
 	"web/micro/rpc/message"
 	"web/micro/rpc/serialize"
 	"web/micro/rpc/serialize/json"
+	"web/micro/rpc/status"
 )
 
-const numOfLengthBytes = 8
+const (
+	numOfLengthBytes = 8
+	// defaultCompressThreshold 默认超过 1KB 才压缩
+	defaultCompressThreshold = 1024
+)
+
+var errUnsupportedCompressor = status.Error(codes.Unimplemented, "micro: 不支持的压缩算法")
 
 // InitService 要为函数类型的字段赋值
 // type service struct{ GetById func() }
@@ -52,116 +61,157 @@ func setFuncField(service Service, p Proxy, s serialize.Serialize) error {
 	for i := 0; i < numField; i++ {
 		fieldTyp := typ.Field(i)
 		fieldVal := val.Field(i)
-		// 要调用canSet，看是否可以修改
-		if fieldVal.CanSet() {
-			// 这个地方才是真正的发起RPC调用的地方
-			fn := func(args []reflect.Value) []reflect.Value {
-
+		// 要调用canSet，看是否可以修改，只处理函数类型的字段
+		if !fieldVal.CanSet() || fieldTyp.Type.Kind() != reflect.Func {
+			continue
+		}
+		// func(ctx context.Context) (Stream, error) 是流式方法，调用的时候打开一个流
+		if isStreamField(fieldTyp.Type) {
+			sp, ok := p.(StreamProxy)
+			if !ok {
+				return fmt.Errorf("rpc: 字段 %s 是流式方法，但是 Proxy 不支持流式调用", fieldTyp.Name)
+			}
+			methodName := fieldTyp.Name
+			fieldVal.Set(reflect.MakeFunc(fieldTyp.Type, func(args []reflect.Value) []reflect.Value {
 				ctx := args[0].Interface().(context.Context)
-				retVal := reflect.New(fieldTyp.Type.Out(0).Elem())
-				reqData, err := s.Encode(args[1].Interface())
+				st, err := sp.NewStream(ctx, service.Name(), methodName)
 				if err != nil {
-					return []reflect.Value{retVal, reflect.ValueOf(err)}
-				}
-				meta := make(map[string]string, 2)
-				if deadline, ok := ctx.Deadline(); ok {
-					// 毫秒数，十进制
-					meta["deadline"] = strconv.FormatInt(deadline.UnixMilli(), 10)
+					return []reflect.Value{reflect.Zero(streamType), reflect.ValueOf(err)}
 				}
+				return []reflect.Value{reflect.ValueOf(st), reflect.Zero(errType)}
+			}))
+			continue
+		}
+		// 签名不对的话现在就报错，不要等到调用的时候才 panic
+		if err := checkMethod(fieldTyp.Type); err != nil {
+			return fmt.Errorf("rpc: 字段 %s 不合法: %w", fieldTyp.Name, err)
+		}
+		// 这个地方才是真正的发起RPC调用的地方
+		fn := func(args []reflect.Value) []reflect.Value {
+			ctx := args[0].Interface().(context.Context)
+			retVal := reflect.New(fieldTyp.Type.Out(0).Elem())
+			err := Call(ctx, p, s, service.Name(), fieldTyp.Name, args[1].Interface(), retVal.Interface())
+			// err 在reflect的零值
+			retErrVal := reflect.Zero(errType)
+			if err != nil {
+				retErrVal = reflect.ValueOf(err)
+			}
+			return []reflect.Value{retVal, retErrVal}
+		}
+		// 设置值给 GetById
+		fnVal := reflect.MakeFunc(fieldTyp.Type, fn)
+		// 这个Set就是篡改成对RPC发起调用的方法
+		fieldVal.Set(fnVal)
+	}
+	return nil
+}
 
-				if isOneway(ctx) {
-					meta = map[string]string{"one-way": "true"}
-				}
-				req := &message.Request{
-					Serializer:  s.Code(),
-					ServiceName: service.Name(),
-					MethodName:  fieldTyp.Name,
-					Meta:        meta,
-					Data:        reqData,
-				}
+func isStreamField(typ reflect.Type) bool {
+	return typ.NumIn() == 1 && typ.In(0) == ctxType &&
+		typ.NumOut() == 2 && typ.Out(0) == streamType && typ.Out(1) == errType
+}
 
-				req.CalculateHeadLength()
-				req.CalculateBodyLength()
+// Call 发起一次 RPC 调用，arg 是请求，reply 是用来接收响应的结构体指针
+// setFuncField 生成的方法和 micro-gen 生成的客户端都是调用它，保证编码方式一致
+func Call(ctx context.Context, p Proxy, s serialize.Serialize,
+	serviceName, methodName string, arg, reply any) error {
+	reqData, err := s.Encode(arg)
+	if err != nil {
+		return err
+	}
+	meta := make(map[string]string, 2)
+	if err = setTimeout(ctx, meta); err != nil {
+		return err
+	}
 
-				// 关键就是这里，这里才是发起rpc调用的方法
-				resp, err := p.Invoke(ctx, req)
-				if err != nil {
-					// Out 返回函数类型的第 i 个输出参数的类型。
-					// err 在reflect的零值
-					return []reflect.Value{retVal, reflect.ValueOf(err)}
-				}
+	if isIdempotent(ctx) {
+		meta[metaIdempotent] = "true"
+	}
 
-				var retErr error
-				if len(resp.Error) > 0 {
-					// 服务端出现error 可以考虑返回，也可以考虑继续执行
-					retErr = errors.New(string(resp.Error))
-				}
+	if isOneway(ctx) {
+		meta = map[string]string{"one-way": "true"}
+	}
+	req := &message.Request{
+		Serializer:  s.Code(),
+		ServiceName: serviceName,
+		MethodName:  methodName,
+		Meta:        meta,
+		Data:        reqData,
+	}
 
-				// TODO 处理响应
-				if len(resp.Data) > 0 {
-					err = s.Decode(resp.Data, retVal.Interface())
-					if err != nil {
-						return nil
-					}
-				}
+	req.CalculateHeadLength()
+	req.CalculateBodyLength()
 
-				var retErrVal reflect.Value
-				if retErr == nil {
-					retErrVal = reflect.Zero(reflect.TypeOf(new(error)).Elem())
-				} else {
-					retErrVal = reflect.ValueOf(retErr)
-				}
+	// 关键就是这里，这里才是发起rpc调用的方法
+	resp, err := p.Invoke(ctx, req)
+	if err != nil {
+		return err
+	}
 
-				return []reflect.Value{retVal, retErrVal}
-			}
-			// 设置值给 GetById
-			fnVal := reflect.MakeFunc(fieldTyp.Type, fn)
-			// 这个Set就是篡改成对RPC发起调用的方法
-			fieldVal.Set(fnVal)
+	var retErr error
+	if len(resp.Error) > 0 {
+		// 服务端出现error 可以考虑返回，也可以考虑继续执行
+		// 错误码也一起解码出来，用户可以用 status.Code(err) 来判断
+		retErr = status.Decode(resp.Error)
+	}
+
+	if len(resp.Data) > 0 {
+		err = s.Decode(resp.Data, reply)
+		if err != nil {
+			return err
 		}
 	}
-	return nil
+	return retErr
 }
 
 type Client struct {
-	// 重构了，使用连接池
-	//addr string
-	// 也可以考虑使用连接池
-	pool       pool.Pool
+	// 直连的时候只有这一个服务端
+	// 原本使用连接池，一个请求独占一个连接
+	// 现在一个连接上可以同时跑多个请求，所以只需要少量的连接
+	endpoint *endpoint
+	// 通过注册中心发现服务端的时候使用，见 NewClientWithRegistry
+	resolver *instanceResolver
+	balancer loadbalance.Balancer
+	connNum  int
+	// 用于生成 RequestId，单调递增
+	reqId      uint32
 	serializer serialize.Serialize
+	// 为 nil 的时候不压缩
+	compressor compress.Compressor
+	// 小于这个大小的数据不压缩，压缩小数据得不偿失
+	compressThreshold int
+	// 为 nil 的时候没有拦截器
+	interceptor ClientInterceptor
+	// key 是 服务名/方法名
+	retryPolicies map[string]RetryPolicy
+	retryThrottle *retryThrottle
+	// 为 nil 的时候不熔断，key 见 breakerKey
+	breakers     *circuitbreaker.Group
+	breakerScope BreakerScope
 }
 
 type ClientOption func(*Client)
 
 func NewClient(addr string, opts ...ClientOption) (*Client, error) {
-	p, err := pool.NewChannelPool(&pool.Config{
-		InitialCap: 1,
-		MaxCap:     30,
-		MaxIdle:    10,
-		Factory: func() (interface{}, error) {
-			conn, err := net.DialTimeout("tcp", addr, time.Second*3)
-			if err != nil {
-				return nil, err
-			}
-			return conn, nil
-		},
-		Close: func(obj interface{}) error {
-			return obj.(net.Conn).Close()
-		},
-		IdleTimeout: time.Second * 60,
-	})
-	if err != nil {
+	res := newClient(opts...)
+	res.endpoint = newEndpoint(addr, res.connNum)
+	// 先建立一个连接，地址不可用的时候尽早返回错误
+	if _, err := res.endpoint.getConn(0); err != nil {
 		return nil, err
 	}
+	return res, nil
+}
+
+func newClient(opts ...ClientOption) *Client {
 	res := &Client{
-		pool:       p,
-		serializer: &json.Serializer{},
+		connNum:           1,
+		serializer:        &json.Serializer{},
+		compressThreshold: defaultCompressThreshold,
 	}
-
 	for _, opt := range opts {
 		opt(res)
 	}
-	return res, nil
+	return res
 }
 
 func ClientWithSerializer(s serialize.Serialize) ClientOption {
@@ -170,57 +220,222 @@ func ClientWithSerializer(s serialize.Serialize) ClientOption {
 	}
 }
 
+func ClientWithCompressor(cp compress.Compressor) ClientOption {
+	return func(c *Client) {
+		c.compressor = cp
+	}
+}
+
+// ClientWithCompressThreshold 设置压缩的阈值，Data 小于 threshold 字节的时候不压缩
+func ClientWithCompressThreshold(threshold int) ClientOption {
+	return func(c *Client) {
+		c.compressThreshold = threshold
+	}
+}
+
+// ClientWithInterceptors 设置客户端拦截器，可以多次调用，按照添加的顺序执行
+func ClientWithInterceptors(interceptors ...ClientInterceptor) ClientOption {
+	return func(c *Client) {
+		if c.interceptor != nil {
+			interceptors = append([]ClientInterceptor{c.interceptor}, interceptors...)
+		}
+		c.interceptor = ChainClientInterceptors(interceptors...)
+	}
+}
+
+// ClientWithConnNum 设置和服务端之间维持的连接数量
+func ClientWithConnNum(n int) ClientOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.connNum = n
+		}
+	}
+}
+
+// ClientWithBalancer 设置负载均衡策略，只对 NewClientWithRegistry 创建的客户端生效
+// 默认是轮询
+func ClientWithBalancer(b loadbalance.Balancer) ClientOption {
+	return func(c *Client) {
+		c.balancer = b
+	}
+}
+
 // Invoke 发送请求给服务端并调用方法，最终获取返回值
 // 把一段二进制编码的调用信息发送给服务端
 func (c *Client) Invoke(ctx context.Context, req *message.Request) (*message.Response, error) {
-	if ctx.Done() != nil {
-		return nil, ctx.Err()
-	}
-
-	ch := make(chan struct{})
-	defer close(ch)
-	var (
-		resp *message.Response
-		err  error
-	)
-	go func() {
-		resp, err = c.doInvoke(ctx, req)
-		ch <- struct{}{}
-	}()
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case <-ch:
-		return resp, err
+	default:
+	}
+	if c.interceptor != nil {
+		return c.interceptor(ctx, req, c.doInvoke)
 	}
+	return c.doInvoke(ctx, req)
 }
 
 func (c *Client) doInvoke(ctx context.Context, req *message.Request) (*message.Response, error) {
-	data := message.EncodeReq(req)
-	// 接下来发送给服务端
-	// 服务端需要提供一个连接
-	res, err := c.send(ctx, data)
+	// 拦截器可能修改了 Meta，需要重新计算头部长度
+	req.CalculateHeadLength()
+	// 重试的时候不能重复压缩
+	if err := c.compress(req); err != nil {
+		return nil, err
+	}
+	return c.invokeWithRetry(ctx, req, c.invokeOnce)
+}
+
+// invokeOnce 每次都重新选择实例和连接，重试的时候可以换一个实例
+func (c *Client) invokeOnce(ctx context.Context, req *message.Request) (*message.Response, error) {
+	req.RequestId = atomic.AddUint32(&c.reqId, 1)
+	// 重试的时候剩下的时间变少了，要重新计算
+	if _, ok := req.Meta[metaTimeout]; ok {
+		if err := setTimeout(ctx, req.Meta); err != nil {
+			return nil, err
+		}
+		req.CalculateHeadLength()
+	}
+	cc, done, err := c.pick(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	return message.DecodeResp(res), nil
+	resp, err := c.send(ctx, cc, req)
+	if err == nil && len(resp.Error) > 0 {
+		// 服务端返回的错误也要让熔断器和负载均衡知道
+		done(status.Decode(resp.Error))
+	} else {
+		done(err)
+	}
+	return resp, err
 }
 
-func (c *Client) send(ctx context.Context, data []byte) ([]byte, error) {
-	val, err := c.pool.Get()
+func (c *Client) send(ctx context.Context, cc *clientConn, req *message.Request) (*message.Response, error) {
+	resp, err := cc.send(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	conn := val.(net.Conn)
+	if err = c.decompress(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
 
-	// 发送请求
-	_, err = conn.Write(data)
+// pick 选出这次调用使用的连接，调用结束之后要调用 done
+func (c *Client) pick(ctx context.Context, req *message.Request) (*clientConn, loadbalance.DoneFunc, error) {
+	if c.resolver == nil {
+		release, err := c.allow(c.endpoint.addr, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		cc, err := c.endpoint.pick()
+		if err != nil {
+			release(err)
+			return nil, nil, err
+		}
+		return cc, release, nil
+	}
+	all := c.resolver.instances()
+	instances := c.available(all, req)
+	for {
+		if len(instances) == 0 && len(all) > 0 && c.breakers != nil {
+			return nil, nil, errCircuitOpen
+		}
+		ins, done, err := c.balancer.Pick(ctx, instances)
+		if err != nil {
+			return nil, nil, err
+		}
+		release, err := c.allow(ins.Address, req)
+		if err != nil {
+			// 半开的实例探测请求已经够了，换一个实例
+			done(nil)
+			instances = without(instances, ins.Address)
+			continue
+		}
+		var cc *clientConn
+		ep, err := c.resolver.endpoint(ins.Address)
+		if err == nil {
+			cc, err = ep.pick()
+		}
+		if err != nil {
+			release(err)
+			done(err)
+			return nil, nil, err
+		}
+		return cc, func(err error) {
+			release(err)
+			done(err)
+		}, nil
+	}
+}
+
+func (c *Client) compress(req *message.Request) error {
+	if c.compressor == nil || len(req.Data) < c.compressThreshold {
+		return nil
+	}
+	data, err := c.compressor.Compress(req.Data)
+	if err != nil {
+		return err
+	}
+	req.Data = data
+	req.Compresser = c.compressor.Code()
+	req.CalculateBodyLength()
+	return nil
+}
+
+func (c *Client) decompress(resp *message.Response) error {
+	if resp.Compresser == 0 {
+		return nil
+	}
+	if c.compressor == nil || c.compressor.Code() != resp.Compresser {
+		return errUnsupportedCompressor
+	}
+	data, err := c.compressor.Decompress(resp.Data)
+	if err != nil {
+		return err
+	}
+	resp.Data = data
+	resp.Compresser = 0
+	resp.CalculateBodyLength()
+	return nil
+}
+
+// NewStream 打开一个流，ctx 被取消的时候会通知服务端中断这个流
+// 流式调用不经过拦截器，也不压缩
+func (c *Client) NewStream(ctx context.Context, serviceName, methodName string) (Stream, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	// 和普通的调用一样带上剩下的时间
+	meta := make(map[string]string, 1)
+	if err := setTimeout(ctx, meta); err != nil {
+		return nil, err
+	}
+	req := &message.Request{
+		RequestId:   atomic.AddUint32(&c.reqId, 1),
+		Serializer:  c.serializer.Code(),
+		Flag:        message.FlagStream,
+		ServiceName: serviceName,
+		MethodName:  methodName,
+		Meta:        meta,
+	}
+	req.CalculateHeadLength()
+	req.CalculateBodyLength()
+	cc, done, err := c.pick(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	if isOneway(ctx) {
-		return nil, errors.New("micro: 这是一个oneway调用，不应检测结果")
+	// 流可能持续很久，这里只统计打开流的结果
+	st, err := cc.openStream(ctx, req, c.serializer)
+	done(err)
+	return st, err
+}
+
+// Close 关闭所有连接，还在等待响应的调用会返回错误
+func (c *Client) Close() error {
+	if c.resolver != nil {
+		return c.resolver.close()
 	}
-	// 读取响应的数据
-	return ReadMsg(conn)
+	c.endpoint.close()
+	return nil
 }
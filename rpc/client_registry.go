@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+	"web/micro/registry"
+	"web/micro/rpc/loadbalance"
+)
+
+const (
+	// defaultResolveTimeout 从注册中心获取实例列表的超时时间
+	defaultResolveTimeout = time.Second * 3
+	// 订阅断开之后重新订阅的等待时间，每失败一次翻倍
+	minResubscribeBackoff = time.Millisecond * 100
+	maxResubscribeBackoff = time.Second * 10
+)
+
+// NewClientWithRegistry 通过注册中心发现 serviceName 的所有实例
+// 每次调用的时候由 Balancer 选出一个实例，每个实例维持自己的连接
+// 注册中心由调用方负责关闭
+func NewClientWithRegistry(serviceName string, r registry.Registry, opts ...ClientOption) (*Client, error) {
+	res := newClient(opts...)
+	if res.balancer == nil {
+		res.balancer = loadbalance.NewRoundRobin()
+	}
+	rs := &instanceResolver{
+		serviceName: serviceName,
+		registry:    r,
+		connNum:     res.connNum,
+		timeout:     defaultResolveTimeout,
+		endpoints:   make(map[string]*endpoint, 8),
+		closeCh:     make(chan struct{}),
+		onRemove:    res.removeInstance,
+	}
+	// 先订阅再获取，避免中间的变更被漏掉
+	events, err := r.Subscribe(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if err = rs.resolve(); err != nil {
+		// 注册中心没有取消订阅的接口，关闭 resolver 之后不会再处理这个订阅，也不会重新订阅
+		_ = rs.close()
+		return nil, err
+	}
+	go rs.watch(events)
+	res.resolver = rs
+	return res, nil
+}
+
+// removeInstance 实例下线之后，清理熔断器和负载均衡里面这个实例的状态
+func (c *Client) removeInstance(addr string) {
+	c.removeBreakers(addr)
+	if rm, ok := c.balancer.(loadbalance.Remover); ok {
+		rm.Remove(addr)
+	}
+}
+
+// instanceResolver 维护某个服务的实例列表，以及到每个实例的连接
+type instanceResolver struct {
+	serviceName string
+	registry    registry.Registry
+	connNum     int
+	timeout     time.Duration
+	// 实例下线的时候调用，见 Client.removeInstance
+	onRemove func(addr string)
+
+	mutex sync.RWMutex
+	ins   []registry.ServiceInstance
+	// key 是实例地址
+	endpoints map[string]*endpoint
+	closed    bool
+	closeCh   chan struct{}
+}
+
+// instances 返回的切片不会再被修改，调用方只能读
+func (r *instanceResolver) instances() []registry.ServiceInstance {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.ins
+}
+
+// endpoint 获取 addr 对应的连接，第一次使用的时候创建
+func (r *instanceResolver) endpoint(addr string) (*endpoint, error) {
+	r.mutex.RLock()
+	ep, ok := r.endpoints[addr]
+	r.mutex.RUnlock()
+	if ok {
+		return ep, nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, errConnClosed
+	}
+	ep, ok = r.endpoints[addr]
+	if !ok {
+		ep = newEndpoint(addr, r.connNum)
+		r.endpoints[addr] = ep
+	}
+	return ep, nil
+}
+
+// resolve 重新获取实例列表，下线的实例的连接会被关闭
+func (r *instanceResolver) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	ins, err := r.registry.ListServices(ctx, r.serviceName)
+	if err != nil {
+		return err
+	}
+	alive := make(map[string]struct{}, len(ins))
+	for _, si := range ins {
+		alive[si.Address] = struct{}{}
+	}
+	var (
+		removed []*endpoint
+		dropped []string
+	)
+	r.mutex.Lock()
+	for _, si := range r.ins {
+		if _, ok := alive[si.Address]; !ok {
+			dropped = append(dropped, si.Address)
+		}
+	}
+	r.ins = ins
+	for addr, ep := range r.endpoints {
+		if _, ok := alive[addr]; !ok {
+			removed = append(removed, ep)
+			delete(r.endpoints, addr)
+		}
+	}
+	r.mutex.Unlock()
+	for _, ep := range removed {
+		ep.close()
+	}
+	for _, addr := range dropped {
+		r.onRemove(addr)
+	}
+	return nil
+}
+
+// watch 注册中心有变更的时候更新实例列表
+// 订阅的 channel 被关闭之后重新订阅，并且重新获取全部实例，中间的变更可能已经丢了
+func (r *instanceResolver) watch(events <-chan registry.Event) {
+	for {
+		if !r.consume(events) {
+			return
+		}
+		events = r.resubscribe()
+		if events == nil {
+			return
+		}
+		// 获取失败的时候继续使用原来的列表，等下一次变更
+		_ = r.resolve()
+	}
+}
+
+// consume 处理订阅的事件，channel 被关闭的时候返回 true，客户端关闭的时候返回 false
+func (r *instanceResolver) consume(events <-chan registry.Event) bool {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return true
+			}
+			r.apply(e)
+		case <-r.closeCh:
+			return false
+		}
+	}
+}
+
+// resubscribe 一直重试到成功为止，客户端关闭的时候返回 nil
+func (r *instanceResolver) resubscribe() <-chan registry.Event {
+	backoff := minResubscribeBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-r.closeCh:
+			return nil
+		}
+		events, err := r.registry.Subscribe(r.serviceName)
+		if err == nil {
+			return events
+		}
+		backoff *= 2
+		if backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+	}
+}
+
+// apply 增删改直接修改本地的列表，其余的情况重新获取全部实例
+func (r *instanceResolver) apply(e registry.Event) {
+	switch e.Type {
+	case registry.EventAdd, registry.EventUpdate, registry.EventDelete:
+	default:
+		// 获取失败的时候继续使用原来的列表，等下一次变更
+		_ = r.resolve()
+		return
+	}
+	r.mutex.Lock()
+	// instances 返回的切片不能修改，这里复制一份
+	ins := make([]registry.ServiceInstance, 0, len(r.ins)+1)
+	for _, si := range r.ins {
+		if si.Address != e.Instance.Address {
+			ins = append(ins, si)
+		}
+	}
+	if e.Type != registry.EventDelete {
+		ins = append(ins, e.Instance)
+	}
+	r.ins = ins
+	ep, ok := r.endpoints[e.Instance.Address]
+	if ok && e.Type == registry.EventDelete {
+		delete(r.endpoints, e.Instance.Address)
+	}
+	r.mutex.Unlock()
+	if e.Type != registry.EventDelete {
+		return
+	}
+	if ok {
+		ep.close()
+	}
+	r.onRemove(e.Instance.Address)
+}
+
+func (r *instanceResolver) close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.closeCh)
+	endpoints := r.endpoints
+	r.endpoints = nil
+	r.mutex.Unlock()
+	for _, ep := range endpoints {
+		ep.close()
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web/micro/registry"
+	"web/micro/registry/memory"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/loadbalance"
+	"web/micro/rpc/status"
+)
+
+func TestClientWithRegistry(t *testing.T) {
+	r := memory.NewRegistry()
+	defer r.Close()
+	addrs := make([]string, 0, 2)
+	for _, msg := range []string{"a", "b"} {
+		server := NewServer()
+		require.NoError(t, server.RegisterService(&UserServiceServer{Msg: msg}))
+		addr := startTestServer(t, server)
+		addrs = append(addrs, addr)
+		require.NoError(t, r.Register(context.Background(), registry.ServiceInstance{Name: "user-service", Address: addr}))
+	}
+
+	client, err := NewClientWithRegistry("user-service", r, ClientWithBalancer(loadbalance.NewRoundRobin()))
+	require.NoError(t, err)
+	defer client.Close()
+	c := &userServiceProxy{}
+	require.NoError(t, client.InitService(c))
+
+	// 轮询，两个实例都会被调用到
+	msgs := make(map[string]int, 2)
+	for i := 0; i < 4; i++ {
+		resp, er := c.GetById(context.Background(), &GetByIdReq{Id: i})
+		require.NoError(t, er)
+		msgs[resp.Msg]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, msgs)
+
+	// 下线一个实例之后，只会调用剩下的那个
+	require.NoError(t, r.UnRegister(context.Background(), registry.ServiceInstance{Name: "user-service", Address: addrs[0]}))
+	require.Eventually(t, func() bool {
+		return len(client.resolver.instances()) == 1
+	}, time.Second, 10*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		resp, er := c.GetById(context.Background(), &GetByIdReq{Id: i})
+		require.NoError(t, er)
+		assert.Equal(t, "b", resp.Msg)
+	}
+
+	// 全部下线
+	require.NoError(t, r.UnRegister(context.Background(), registry.ServiceInstance{Name: "user-service", Address: addrs[1]}))
+	require.Eventually(t, func() bool {
+		return len(client.resolver.instances()) == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = c.GetById(context.Background(), &GetByIdReq{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// TestClientWithRegistry_Resubscribe 订阅断开之后重新订阅，并且拿到断开期间的变化
+func TestClientWithRegistry_Resubscribe(t *testing.T) {
+	r := &resubscribeRegistry{Registry: memory.NewRegistry()}
+	defer r.Close()
+	a := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	b := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082"}
+	require.NoError(t, r.Register(context.Background(), a))
+	client, err := NewClientWithRegistry("user-service", r)
+	require.NoError(t, err)
+	defer client.Close()
+
+	close(r.first)
+	// 订阅断开的时候上线的实例，收不到事件
+	require.NoError(t, r.Register(context.Background(), b))
+	require.Eventually(t, func() bool {
+		return len(client.resolver.instances()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&r.subscribes))
+}
+
+// TestClientWithRegistry_ResolveFailed 第一次获取实例失败的时候返回错误，不会留下订阅的协程
+func TestClientWithRegistry_ResolveFailed(t *testing.T) {
+	r := &resubscribeRegistry{Registry: &failedListRegistry{Registry: memory.NewRegistry()}}
+	defer r.Close()
+	client, err := NewClientWithRegistry("user-service", r)
+	assert.Equal(t, errListFailed, err)
+	assert.Nil(t, client)
+
+	// 订阅断开之后没有人重新订阅
+	close(r.first)
+	time.Sleep(2 * minResubscribeBackoff)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&r.subscribes))
+}
+
+var errListFailed = errors.New("mock list error")
+
+// failedListRegistry 获取实例列表总是失败
+type failedListRegistry struct {
+	registry.Registry
+}
+
+func (r *failedListRegistry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	return nil, errListFailed
+}
+
+// resubscribeRegistry 第一次订阅返回的 channel 由测试关闭，模拟订阅断开
+type resubscribeRegistry struct {
+	registry.Registry
+	subscribes int32
+	first      chan registry.Event
+}
+
+func (r *resubscribeRegistry) Subscribe(serviceName string) (<-chan registry.Event, error) {
+	if atomic.AddInt32(&r.subscribes, 1) == 1 {
+		r.first = make(chan registry.Event)
+		return r.first, nil
+	}
+	return r.Registry.Subscribe(serviceName)
+}
@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"web/micro/rpc/compress"
+	"web/micro/rpc/compress/gzip"
+	"web/micro/rpc/compress/snappy"
+	"web/micro/rpc/serialize/json"
+)
+
+// TestClient_Multiplexing 多个请求共用一个连接，响应乱序返回也能对应上
+func TestClient_Multiplexing(t *testing.T) {
+	server := NewServer()
+	require.NoError(t, server.RegisterService(&sleepService{}))
+	addr := startTestServer(t, server)
+
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer client.Close()
+	usClient := &SleepService{}
+	require.NoError(t, client.InitService(usClient))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 10; i > 0; i-- {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			// 越早发出的请求睡得越久，响应一定是乱序的
+			resp, er := usClient.GetById(context.Background(), &GetByIdReq{Id: id})
+			assert.NoError(t, er)
+			assert.Equal(t, strconv.Itoa(id), resp.Msg)
+		}(i)
+	}
+	wg.Wait()
+	// 如果是串行处理，至少需要 550ms
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestClient_Compress(t *testing.T) {
+	server := NewServer(ServerWithCompressThreshold(10))
+	require.NoError(t, server.RegisterService(&echoService{}))
+	addr := startTestServer(t, server)
+
+	testCases := []struct {
+		name string
+		c    compress.Compressor
+		msg  string
+	}{
+		{
+			name: "gzip",
+			c:    &gzip.Compressor{},
+			msg:  strings.Repeat("hello", 100),
+		},
+		{
+			name: "snappy",
+			c:    &snappy.Compressor{},
+			msg:  strings.Repeat("hello", 100),
+		},
+		{
+			name: "below threshold",
+			c:    &gzip.Compressor{},
+			msg:  "hello",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(addr, ClientWithCompressor(tc.c), ClientWithCompressThreshold(10))
+			require.NoError(t, err)
+			defer client.Close()
+			echo := &EchoService{}
+			require.NoError(t, client.InitService(echo))
+			resp, err := echo.Echo(context.Background(), &EchoReq{Msg: tc.msg})
+			require.NoError(t, err)
+			assert.Equal(t, tc.msg, resp.Msg)
+		})
+	}
+}
+
+// TestUserServiceClient micro-gen 生成的代码和反射的实现可以互相调用
+func TestUserServiceClient(t *testing.T) {
+	reflection := NewServer()
+	require.NoError(t, reflection.RegisterService(&UserServiceServer{Msg: "hello"}))
+	reflectionAddr := startTestServer(t, reflection)
+	dispatcher := NewServer()
+	require.NoError(t, dispatcher.RegisterService(NewUserServiceDispatcher(&UserServiceServer{Msg: "hello"})))
+	dispatcherAddr := startTestServer(t, dispatcher)
+
+	testCases := []struct {
+		name string
+		addr string
+		// 用生成的客户端还是反射的客户端
+		generated bool
+	}{
+		{
+			name:      "generated client, reflection server",
+			addr:      reflectionAddr,
+			generated: true,
+		},
+		{
+			name: "reflection client, dispatcher server",
+			addr: dispatcherAddr,
+		},
+		{
+			name:      "generated client, dispatcher server",
+			addr:      dispatcherAddr,
+			generated: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(tc.addr)
+			require.NoError(t, err)
+			defer client.Close()
+			var getById func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+			if tc.generated {
+				getById = NewUserServiceClient(client, &json.Serializer{}).GetById
+			} else {
+				c := &userServiceProxy{}
+				require.NoError(t, client.InitService(c))
+				getById = c.GetById
+			}
+			resp, err := getById(context.Background(), &GetByIdReq{Id: 123})
+			require.NoError(t, err)
+			assert.Equal(t, "hello", resp.Msg)
+		})
+	}
+}
+
+type userServiceProxy struct {
+	GetById func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+}
+
+func (u *userServiceProxy) Name() string {
+	return "user-service"
+}
+
+func startTestServer(t *testing.T, server *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return listener.Addr().String()
+}
+
+type EchoReq struct {
+	Msg string
+}
+
+type EchoResp struct {
+	Msg string
+}
+
+type EchoService struct {
+	Echo func(ctx context.Context, req *EchoReq) (*EchoResp, error)
+}
+
+func (e *EchoService) Name() string {
+	return "echo-service"
+}
+
+type echoService struct{}
+
+func (e *echoService) Echo(ctx context.Context, req *EchoReq) (*EchoResp, error) {
+	return &EchoResp{Msg: req.Msg}, nil
+}
+
+func (e *echoService) Name() string {
+	return "echo-service"
+}
+
+type SleepService struct {
+	GetById func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+}
+
+func (s *SleepService) Name() string {
+	return "sleep-service"
+}
+
+type sleepService struct{}
+
+func (s *sleepService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	time.Sleep(time.Duration(req.Id) * 10 * time.Millisecond)
+	return &GetByIdResp{Msg: strconv.Itoa(req.Id)}, nil
+}
+
+func (s *sleepService) Name() string {
+	return "sleep-service"
+}
@@ -0,0 +1,57 @@
+package compress_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"web/micro/rpc/compress"
+	"web/micro/rpc/compress/gzip"
+	"web/micro/rpc/compress/snappy"
+	"web/micro/rpc/compress/zlib"
+)
+
+func TestCompressor(t *testing.T) {
+	testCases := []struct {
+		name string
+		c    compress.Compressor
+		data []byte
+	}{
+		{
+			name: "gzip",
+			c:    &gzip.Compressor{},
+			data: bytes.Repeat([]byte("hello world"), 100),
+		},
+		{
+			name: "zlib",
+			c:    &zlib.Compressor{},
+			data: bytes.Repeat([]byte("hello world"), 100),
+		},
+		{
+			name: "snappy",
+			c:    &snappy.Compressor{},
+			data: bytes.Repeat([]byte("hello world"), 100),
+		},
+		{
+			name: "gzip empty",
+			c:    &gzip.Compressor{},
+			data: []byte{},
+		},
+		{
+			name: "snappy empty",
+			c:    &snappy.Compressor{},
+			data: []byte{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed, err := tc.c.Compress(tc.data)
+			require.NoError(t, err)
+			data, err := tc.c.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, len(tc.data), len(data))
+			assert.True(t, bytes.Equal(tc.data, data))
+		})
+	}
+}
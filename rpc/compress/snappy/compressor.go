@@ -0,0 +1,18 @@
+package snappy
+
+import "github.com/golang/snappy"
+
+// Compressor 使用 snappy 的 block 格式，纯 Go 实现，压缩率不高但是很快
+type Compressor struct{}
+
+func (c *Compressor) Code() uint8 {
+	return 3
+}
+
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *Compressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
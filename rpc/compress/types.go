@@ -0,0 +1,8 @@
+package compress
+
+type Compressor interface {
+	// Code 用一个字节来表示压缩算法，0 表示没有压缩
+	Code() uint8
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
@@ -0,0 +1,249 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/serialize"
+	"web/micro/rpc/status"
+)
+
+var errConnClosed = status.Error(codes.Unavailable, "micro: 连接已关闭")
+
+// clientConn 在一个 TCP 连接上同时跑多个请求
+// 请求通过 RequestId 和响应对应起来，由一个读协程负责把响应分发给等待的调用方
+type clientConn struct {
+	conn net.Conn
+	// 多个请求共用一个连接，写入要串行，否则数据会交错
+	writeMutex sync.Mutex
+
+	mutex sync.Mutex
+	// 正在等待响应的请求
+	pending map[uint32]chan *message.Response
+	// 还没有结束的流
+	streams map[uint32]*clientStream
+	// 连接不可用的原因，不为 nil 说明连接已经关闭
+	err error
+}
+
+func newClientConn(conn net.Conn) *clientConn {
+	res := &clientConn{
+		conn:    conn,
+		pending: make(map[uint32]chan *message.Response, 16),
+		streams: make(map[uint32]*clientStream, 4),
+	}
+	go res.readLoop()
+	return res
+}
+
+// readLoop 不断读取响应，按照 RequestId 找到等待的调用方
+func (c *clientConn) readLoop() {
+	for {
+		data, err := ReadMsg(c.conn)
+		if err != nil {
+			c.close(err)
+			return
+		}
+		resp := message.DecodeResp(data)
+		if resp.Flag&message.FlagStream != 0 {
+			c.mutex.Lock()
+			st, ok := c.streams[resp.RequestId]
+			c.mutex.Unlock()
+			if ok {
+				st.onFrame(resp)
+			}
+			continue
+		}
+		c.mutex.Lock()
+		ch, ok := c.pending[resp.RequestId]
+		delete(c.pending, resp.RequestId)
+		c.mutex.Unlock()
+		// 没找到说明调用方已经超时放弃了，直接丢掉
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+func (c *clientConn) send(ctx context.Context, req *message.Request) (*message.Response, error) {
+	oneway := isOneway(ctx)
+	var ch chan *message.Response
+	// oneway 调用不会有响应，不需要等待
+	if !oneway {
+		// 缓冲为 1，读协程发送的时候不会因为调用方已经走了而阻塞
+		ch = make(chan *message.Response, 1)
+		c.mutex.Lock()
+		if c.err != nil {
+			c.mutex.Unlock()
+			return nil, c.err
+		}
+		c.pending[req.RequestId] = ch
+		c.mutex.Unlock()
+	}
+
+	if err := c.write(req); err != nil {
+		return nil, err
+	}
+	if oneway {
+		return nil, errors.New("micro: 这是一个oneway调用，不应检测结果")
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, c.closedErr()
+		}
+		return resp, nil
+	case <-ctx.Done():
+		c.mutex.Lock()
+		_, ok := c.pending[req.RequestId]
+		delete(c.pending, req.RequestId)
+		c.mutex.Unlock()
+		// 响应还没有到，告诉服务端不用再处理了
+		if ok {
+			c.cancel(req.RequestId)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// cancel 发送取消帧，发送失败也没关系，连接断开的时候服务端一样会取消
+func (c *clientConn) cancel(id uint32) {
+	req := &message.Request{
+		RequestId: id,
+		Flag:      message.FlagCancel,
+	}
+	req.CalculateHeadLength()
+	req.CalculateBodyLength()
+	_ = c.write(req)
+}
+
+func (c *clientConn) write(req *message.Request) error {
+	data := message.EncodeReq(req)
+	c.writeMutex.Lock()
+	_, err := c.conn.Write(data)
+	c.writeMutex.Unlock()
+	if err != nil {
+		c.close(err)
+	}
+	return err
+}
+
+// openStream 打开一个流，第一帧只带服务名和方法名
+func (c *clientConn) openStream(ctx context.Context, req *message.Request, s serialize.Serialize) (*clientStream, error) {
+	st := newClientStream(ctx, c, req.RequestId, s)
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	c.streams[req.RequestId] = st
+	c.mutex.Unlock()
+	if err := c.write(req); err != nil {
+		return nil, err
+	}
+	go st.watch()
+	return st, nil
+}
+
+func (c *clientConn) removeStream(id uint32) {
+	c.mutex.Lock()
+	delete(c.streams, id)
+	c.mutex.Unlock()
+}
+
+func (c *clientConn) closedErr() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+func (c *clientConn) isClosed() bool {
+	return c.closedErr() != nil
+}
+
+// close 关闭连接，并且通知所有还在等待的调用方
+// 读写失败的错误（比如 io.EOF）统一转成 Unavailable，调用方可以按照重试策略重试
+// 流也不会把连接断开当成对端正常结束
+func (c *clientConn) close(err error) {
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return
+	}
+	if err == nil {
+		err = errConnClosed
+	} else if _, ok := status.FromError(err); !ok {
+		err = status.Errorf(codes.Unavailable, "micro: 连接已断开: %v", err)
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	streams := c.streams
+	c.streams = nil
+	c.mutex.Unlock()
+
+	for _, ch := range pending {
+		close(ch)
+	}
+	for _, st := range streams {
+		st.finish(err)
+	}
+	_ = c.conn.Close()
+}
+
+// endpoint 和一个服务端地址之间的所有连接，连接在第一次使用的时候才建立
+type endpoint struct {
+	addr  string
+	mutex sync.Mutex
+	conns []*clientConn
+	// 轮询选择连接
+	next uint32
+}
+
+func newEndpoint(addr string, connNum int) *endpoint {
+	return &endpoint{
+		addr:  addr,
+		conns: make([]*clientConn, connNum),
+	}
+}
+
+// pick 轮询选出一个连接
+func (e *endpoint) pick() (*clientConn, error) {
+	idx := int(atomic.AddUint32(&e.next, 1) % uint32(len(e.conns)))
+	return e.getConn(idx)
+}
+
+// getConn 获取第 idx 个连接，连接不存在或者已经断开的时候重新建立
+func (e *endpoint) getConn(idx int) (*clientConn, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	cc := e.conns[idx]
+	if cc != nil && !cc.isClosed() {
+		return cc, nil
+	}
+	conn, err := net.DialTimeout("tcp", e.addr, time.Second*3)
+	if err != nil {
+		return nil, err
+	}
+	cc = newClientConn(conn)
+	e.conns[idx] = cc
+	return cc, nil
+}
+
+// close 关闭所有连接，还在等待响应的调用会返回错误
+func (e *endpoint) close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for i, cc := range e.conns {
+		if cc != nil {
+			cc.close(errConnClosed)
+			e.conns[i] = nil
+		}
+	}
+}
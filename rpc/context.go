@@ -1,6 +1,17 @@
 package rpc
 
-import "context"
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+const (
+	// metaTimeout 剩下的毫秒数，用相对时间不受两边时钟偏差的影响
+	metaTimeout = "timeout"
+	// metaDeadline 旧版本客户端发送的绝对时间，毫秒时间戳
+	metaDeadline = "deadline"
+)
 
 type onewayKey struct {
 }
@@ -14,3 +25,48 @@ func isOneway(ctx context.Context) bool {
 	oneway, ok := val.(bool)
 	return ok && oneway
 }
+
+type idempotentKey struct {
+}
+
+// CtxWithIdempotent 标记这次调用是幂等的，配置了重试策略的时候失败了可以重试
+func CtxWithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	val := ctx.Value(idempotentKey{})
+	idempotent, ok := val.(bool)
+	return ok && idempotent
+}
+
+// setTimeout 把 ctx 剩下的时间写进 Meta，已经超时的时候直接返回错误
+func setTimeout(ctx context.Context, meta map[string]string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return context.DeadlineExceeded
+	}
+	// 向下取整，宁可让下游早一点超时，不足一毫秒的按一毫秒算
+	ms := remaining.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	meta[metaTimeout] = strconv.FormatInt(ms, 10)
+	return nil
+}
+
+// ctxWithTimeout 按照 Meta 里面的超时时间设置 ctx
+// 服务端的方法再发起调用的时候，用的就是剩下的时间
+func ctxWithTimeout(ctx context.Context, meta map[string]string) (context.Context, context.CancelFunc) {
+	if ms, err := strconv.ParseInt(meta[metaTimeout], 10, 64); err == nil {
+		return context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+	}
+	if ms, err := strconv.ParseInt(meta[metaDeadline], 10, 64); err == nil {
+		return context.WithDeadline(ctx, time.UnixMilli(ms))
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,205 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMeta(t *testing.T) {
+	testCases := []struct {
+		name    string
+		ctx     func() context.Context
+		meta    map[string]string
+		wantErr error
+		// 服务端 ctx 剩下的时间大概是多少，0 表示没有超时时间
+		wantRemaining time.Duration
+	}{
+		{
+			name: "no deadline",
+			ctx:  context.Background,
+			meta: map[string]string{},
+		},
+		{
+			name: "relative",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				t.Cleanup(cancel)
+				return ctx
+			},
+			meta:          map[string]string{},
+			wantRemaining: time.Second,
+		},
+		{
+			name: "expired",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+				t.Cleanup(cancel)
+				return ctx
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "legacy deadline",
+			ctx:  context.Background,
+			meta: map[string]string{
+				metaDeadline: strconv.FormatInt(time.Now().Add(time.Second).UnixMilli(), 10),
+			},
+			wantRemaining: time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := tc.meta
+			if meta == nil {
+				meta = map[string]string{}
+			}
+			err := setTimeout(tc.ctx(), meta)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			ctx, cancel := ctxWithTimeout(context.Background(), meta)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			assert.Equal(t, tc.wantRemaining != 0, ok)
+			if ok {
+				assert.InDelta(t, tc.wantRemaining, time.Until(deadline), float64(50*time.Millisecond))
+			}
+		})
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 调用发出去之后怎么让客户端放弃
+		abort func(cancel context.CancelFunc, client *Client)
+	}{
+		{
+			name: "cancel frame",
+			abort: func(cancel context.CancelFunc, client *Client) {
+				cancel()
+			},
+		},
+		{
+			name: "connection closed",
+			abort: func(cancel context.CancelFunc, client *Client) {
+				_ = client.Close()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &blockService{started: make(chan struct{}, 1), result: make(chan error, 1)}
+			server := NewServer()
+			require.NoError(t, server.RegisterService(service))
+			client, err := NewClient(startTestServer(t, server))
+			require.NoError(t, err)
+			defer client.Close()
+			svc := &BlockService{}
+			require.NoError(t, client.InitService(svc))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				_, _ = svc.Wait(ctx, &GetByIdReq{})
+			}()
+			<-service.started
+			tc.abort(cancel, client)
+			select {
+			case err = <-service.result:
+				assert.Equal(t, context.Canceled, err)
+			case <-time.After(time.Second):
+				t.Fatal("服务端的方法没有被取消")
+			}
+		})
+	}
+}
+
+// TestClient_PropagateTimeout 服务端的方法再发起调用的时候，带上的是剩下的时间
+func TestClient_PropagateTimeout(t *testing.T) {
+	block := &blockService{started: make(chan struct{}, 1), result: make(chan error, 1)}
+	server := NewServer()
+	require.NoError(t, server.RegisterService(block))
+	downstream, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer downstream.Close()
+	blockSvc := &BlockService{}
+	require.NoError(t, downstream.InitService(blockSvc))
+
+	relay := &relayService{next: blockSvc}
+	server = NewServer()
+	require.NoError(t, server.RegisterService(relay))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	relaySvc := &RelayService{}
+	require.NoError(t, client.InitService(relaySvc))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	wantDeadline, _ := ctx.Deadline()
+	_, _ = relaySvc.Wait(ctx, &GetByIdReq{})
+	select {
+	case <-block.result:
+	case <-time.After(time.Second):
+		t.Fatal("下游的方法没有结束")
+	}
+	// 相对时间不包括网络传输的时间，所以允许有一点误差
+	assert.WithinDuration(t, wantDeadline, block.deadline, 20*time.Millisecond)
+}
+
+type BlockService struct {
+	Wait func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+}
+
+func (b *BlockService) Name() string {
+	return "block-service"
+}
+
+// blockService 一直等到 ctx 结束，把 ctx 的错误放进 result
+type blockService struct {
+	started chan struct{}
+	result  chan error
+	// 收到请求的时候 ctx 的超时时间
+	deadline time.Time
+}
+
+func (b *blockService) Name() string {
+	return "block-service"
+}
+
+func (b *blockService) Wait(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	b.deadline, _ = ctx.Deadline()
+	b.started <- struct{}{}
+	<-ctx.Done()
+	b.result <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+type RelayService struct {
+	Wait func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+}
+
+func (r *RelayService) Name() string {
+	return "relay-service"
+}
+
+// relayService 把请求转发给下游
+type relayService struct {
+	next *BlockService
+}
+
+func (r *relayService) Name() string {
+	return "relay-service"
+}
+
+func (r *relayService) Wait(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	return r.next.Wait(ctx, req)
+}
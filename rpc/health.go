@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"context"
+	"web/micro/health"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+// HealthServiceName 内置的健康检查服务，每个 Server 都会自动注册
+const HealthServiceName = "micro.health"
+
+type HealthCheckReq struct {
+	// 为空的时候检查整个进程
+	Service string
+}
+
+type HealthCheckResp struct {
+	Status health.Status
+}
+
+// HealthService 客户端使用，InitService 之后就可以检查服务端的健康状态
+type HealthService struct {
+	Check func(ctx context.Context, req *HealthCheckReq) (*HealthCheckResp, error)
+}
+
+func (h *HealthService) Name() string {
+	return HealthServiceName
+}
+
+type healthService struct {
+	s *health.Server
+}
+
+func (h *healthService) Name() string {
+	return HealthServiceName
+}
+
+func (h *healthService) Check(ctx context.Context, req *HealthCheckReq) (*HealthCheckResp, error) {
+	st, err := h.s.Check(req.Service)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "rpc: 未知的服务 %s", req.Service)
+	}
+	return &HealthCheckResp{Status: st}, nil
+}
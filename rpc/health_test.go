@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"web/micro/health"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+func TestServer_Health(t *testing.T) {
+	server := NewServer()
+	require.NoError(t, server.RegisterService(&UserServiceServer{}))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	hs := &HealthService{}
+	require.NoError(t, client.InitService(hs))
+
+	testCases := []struct {
+		name       string
+		before     func()
+		service    string
+		wantStatus health.Status
+		wantCode   codes.Code
+	}{
+		{
+			name:       "process",
+			wantStatus: health.StatusServing,
+		},
+		{
+			name:       "registered service",
+			service:    "user-service",
+			wantStatus: health.StatusServing,
+		},
+		{
+			name: "not serving",
+			before: func() {
+				server.Health().SetServingStatus("user-service", health.StatusNotServing)
+			},
+			service:    "user-service",
+			wantStatus: health.StatusNotServing,
+		},
+		{
+			name:     "unknown service",
+			service:  "order-service",
+			wantCode: codes.NotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.before != nil {
+				tc.before()
+			}
+			resp, err := hs.Check(context.Background(), &HealthCheckReq{Service: tc.service})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantStatus, resp.Status)
+		})
+	}
+}
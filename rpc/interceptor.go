@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"context"
+	"web/micro/rpc/message"
+)
+
+// Handler 真正处理请求的方法，客户端对应发送请求，服务端对应调用服务
+type Handler func(ctx context.Context, req *message.Request) (*message.Response, error)
+
+// ClientInterceptor 客户端拦截器，包在 Proxy.Invoke 的外面
+// 可以修改 req.Meta，可以不调用 next 直接返回，也可以处理 next 返回的响应
+type ClientInterceptor func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error)
+
+// ServerInterceptor 服务端拦截器，包在 Server.Invoke 的外面
+// 拿到的 req 已经解码、解压，但是 Data 还没有反序列化
+// 打开流的时候也会调用，这时 req.Flag 带有 message.FlagStream，next 在流结束之后才返回，响应是 nil
+type ServerInterceptor func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error)
+
+// ChainClientInterceptors 把多个拦截器组合成一个，排在前面的在最外层
+func ChainClientInterceptors(interceptors ...ClientInterceptor) ClientInterceptor {
+	return func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next = bindClient(interceptors[i], next)
+		}
+		return next(ctx, req)
+	}
+}
+
+// ChainServerInterceptors 把多个拦截器组合成一个，排在前面的在最外层
+func ChainServerInterceptors(interceptors ...ServerInterceptor) ServerInterceptor {
+	return func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next = bindServer(interceptors[i], next)
+		}
+		return next(ctx, req)
+	}
+}
+
+func bindClient(interceptor ClientInterceptor, next Handler) Handler {
+	return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		return interceptor(ctx, req, next)
+	}
+}
+
+func bindServer(interceptor ServerInterceptor, next Handler) Handler {
+	return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		return interceptor(ctx, req, next)
+	}
+}
@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/status"
+)
+
+func TestInterceptors(t *testing.T) {
+	var logs []string
+	record := func(name string) ClientInterceptor {
+		return func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+			logs = append(logs, name+" before")
+			resp, err := next(ctx, req)
+			logs = append(logs, name+" after")
+			return resp, err
+		}
+	}
+	server := NewServer(ServerWithInterceptors(func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+		if req.Meta["token"] != "abc" {
+			return nil, status.Error(codes.PermissionDenied, "micro: 没有权限")
+		}
+		return next(ctx, req)
+	}))
+	require.NoError(t, server.RegisterService(&echoService{}))
+	addr := startTestServer(t, server)
+
+	testCases := []struct {
+		name     string
+		opts     []ClientOption
+		wantLogs []string
+		wantErr  error
+	}{
+		{
+			name: "chain",
+			opts: []ClientOption{
+				ClientWithInterceptors(record("first"), record("second")),
+				ClientWithInterceptors(record("third"), func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+					req.Meta["token"] = "abc"
+					return next(ctx, req)
+				}),
+			},
+			wantLogs: []string{"first before", "second before", "third before",
+				"third after", "second after", "first after"},
+		},
+		{
+			name:     "rejected by server",
+			opts:     []ClientOption{ClientWithInterceptors(record("first"))},
+			wantLogs: []string{"first before", "first after"},
+			wantErr:  status.Error(codes.PermissionDenied, "micro: 没有权限"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs = nil
+			client, err := NewClient(addr, tc.opts...)
+			require.NoError(t, err)
+			defer client.Close()
+			echo := &EchoService{}
+			require.NoError(t, client.InitService(echo))
+			_, err = echo.Echo(context.Background(), &EchoReq{Msg: "hello"})
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantLogs, logs)
+		})
+	}
+}
+
+// TestServerInterceptor_ShortCircuit 拦截器自己构造响应直接返回，客户端也能收到
+func TestServerInterceptor_ShortCircuit(t *testing.T) {
+	server := NewServer(ServerWithInterceptors(func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+		return &message.Response{Data: []byte(`{"Msg":"cached"}`)}, nil
+	}))
+	require.NoError(t, server.RegisterService(&echoService{}))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	echo := &EchoService{}
+	require.NoError(t, client.InitService(echo))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := echo.Echo(ctx, &EchoReq{Msg: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "cached", resp.Msg)
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"web/micro/ratelimit"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/status"
+)
+
+// LimitScope 决定哪些请求共用一个限流器，返回空字符串的请求不限流
+type LimitScope func(req *message.Request) string
+
+// LimitGlobal 所有的请求共用一个限流器
+func LimitGlobal(req *message.Request) string {
+	return "*"
+}
+
+// LimitPerService 每个服务一个限流器
+func LimitPerService(req *message.Request) string {
+	return req.ServiceName
+}
+
+// LimitPerMethod 每个方法一个限流器
+func LimitPerMethod(req *message.Request) string {
+	return req.ServiceName + "/" + req.MethodName
+}
+
+// LimitPerCaller 按照 Meta 里面 key 对应的调用方标识限流，没有带标识的请求不限流
+func LimitPerCaller(key string) LimitScope {
+	return func(req *message.Request) string {
+		return req.Meta[key]
+	}
+}
+
+// LimitServerInterceptor 限流的拦截器，被拒绝的请求返回 ResourceExhausted，流式方法整个流算一个请求
+// newLimiter 在第一次见到某个 key 的时候创建限流器，健康检查不限流
+// 限流器默认最多保留 10000 个，可以用 ratelimit.GroupWithMaxSize 调整
+func LimitServerInterceptor(scope LimitScope, newLimiter func(key string) ratelimit.Limiter, opts ...ratelimit.GroupOption) ServerInterceptor {
+	group := ratelimit.NewGroup(newLimiter, opts...)
+	return func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+		key := scope(req)
+		if key == "" || req.ServiceName == HealthServiceName {
+			return next(ctx, req)
+		}
+		done, err := group.Get(key).Allow()
+		if err != nil {
+			return nil, status.Errorf(codes.ResourceExhausted, "rpc: 请求被限流 %s", key)
+		}
+		resp, err := next(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// ServerWithLimiter 添加一个限流器，可以多次调用，比如全局限制并发的同时再按照调用方限制速率
+func ServerWithLimiter(scope LimitScope, newLimiter func(key string) ratelimit.Limiter, opts ...ratelimit.GroupOption) ServerOption {
+	return ServerWithInterceptors(LimitServerInterceptor(scope, newLimiter, opts...))
+}
+
+// ServerWithMaxConns 最多同时保持 n 个连接，默认不限制
+// 超过的连接上的第一个请求返回 ResourceExhausted，然后关闭连接
+func ServerWithMaxConns(n int) ServerOption {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// ServerWithMaxConnRequests 每个连接上最多同时处理 n 个请求和流，默认是 1000，n <= 0 表示不限制
+// 超过的请求直接返回 ResourceExhausted，不会停止读取这个连接，取消帧和流的帧不受影响
+func ServerWithMaxConnRequests(n int) ServerOption {
+	return func(s *Server) {
+		s.maxConnRequests = n
+	}
+}
@@ -0,0 +1,189 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+	"web/micro/health"
+	"web/micro/ratelimit"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/status"
+)
+
+func TestServer_Limiter(t *testing.T) {
+	server := NewServer(
+		ServerWithLimiter(LimitPerMethod, func(key string) ratelimit.Limiter {
+			return ratelimit.NewConcurrency(1)
+		}),
+		ServerWithLimiter(LimitPerCaller("caller"), func(key string) ratelimit.Limiter {
+			return ratelimit.NewTokenBucket(0.001, 1)
+		}),
+	)
+	require.NoError(t, server.RegisterService(&sleepService{}))
+	addr := startTestServer(t, server)
+
+	newSvc := func(caller string) *SleepService {
+		client, err := NewClient(addr, ClientWithInterceptors(
+			func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+				if caller != "" {
+					req.Meta["caller"] = caller
+				}
+				return next(ctx, req)
+			}))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = client.Close()
+		})
+		svc := &SleepService{}
+		require.NoError(t, client.InitService(svc))
+		return svc
+	}
+
+	t.Run("concurrency per method", func(t *testing.T) {
+		svc := newSvc("")
+		callErr := make(chan error, 1)
+		go func() {
+			_, er := svc.GetById(context.Background(), &GetByIdReq{Id: 50})
+			callErr <- er
+		}()
+		time.Sleep(20 * time.Millisecond)
+		_, err := svc.GetById(context.Background(), &GetByIdReq{Id: 1})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.NoError(t, <-callErr)
+		_, err = svc.GetById(context.Background(), &GetByIdReq{Id: 1})
+		assert.NoError(t, err)
+	})
+
+	t.Run("rate per caller", func(t *testing.T) {
+		svcA, svcB := newSvc("a"), newSvc("b")
+		_, err := svcA.GetById(context.Background(), &GetByIdReq{Id: 1})
+		assert.NoError(t, err)
+		_, err = svcA.GetById(context.Background(), &GetByIdReq{Id: 1})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		_, err = svcB.GetById(context.Background(), &GetByIdReq{Id: 1})
+		assert.NoError(t, err)
+	})
+
+	t.Run("health not limited", func(t *testing.T) {
+		client, err := NewClient(addr, ClientWithInterceptors(
+			func(ctx context.Context, req *message.Request, next Handler) (*message.Response, error) {
+				req.Meta["caller"] = "a"
+				return next(ctx, req)
+			}))
+		require.NoError(t, err)
+		defer client.Close()
+		hs := &HealthService{}
+		require.NoError(t, client.InitService(hs))
+		resp, err := hs.Check(context.Background(), &HealthCheckReq{})
+		require.NoError(t, err)
+		assert.Equal(t, health.StatusServing, resp.Status)
+	})
+}
+
+// TestServer_LimiterStream 打开流的时候限流，流结束之后才归还名额
+func TestServer_LimiterStream(t *testing.T) {
+	server := NewServer(ServerWithLimiter(LimitPerMethod, func(key string) ratelimit.Limiter {
+		return ratelimit.NewConcurrency(1)
+	}))
+	require.NoError(t, server.RegisterService(&streamService{}))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	svc := &StreamService{}
+	require.NoError(t, client.InitService(svc))
+
+	echo := func(st Stream) error {
+		if er := st.Send(&GetByIdReq{Id: 1}); er != nil {
+			return er
+		}
+		return st.Recv(&GetByIdReq{})
+	}
+	first, err := svc.Echo(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, echo(first))
+
+	second, err := svc.Echo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(second.Recv(&GetByIdReq{})))
+
+	require.NoError(t, first.CloseSend())
+	require.Equal(t, io.EOF, first.Recv(&GetByIdReq{}))
+	third, err := svc.Echo(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, echo(third))
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	server := NewServer(ServerWithMaxConns(1))
+	require.NoError(t, server.RegisterService(&sleepService{}))
+	addr := startTestServer(t, server)
+
+	testCases := []struct {
+		name     string
+		wantCode codes.Code
+	}{
+		{name: "first"},
+		// 第二个连接上的请求被拒绝，然后连接被关闭
+		{name: "second", wantCode: codes.ResourceExhausted},
+	}
+
+	// 第一个连接要一直保持到最后
+	var clients []*Client
+	defer func() {
+		for _, c := range clients {
+			_ = c.Close()
+		}
+	}()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(addr)
+			require.NoError(t, err)
+			clients = append(clients, client)
+			svc := &SleepService{}
+			require.NoError(t, client.InitService(svc))
+			_, err = svc.GetById(context.Background(), &GetByIdReq{Id: 1})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestServer_MaxConnRequests(t *testing.T) {
+	block := &blockService{started: make(chan struct{}, 1), result: make(chan error, 1)}
+	server := NewServer(ServerWithMaxConnRequests(1))
+	require.NoError(t, server.RegisterService(block))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	svc := &BlockService{}
+	require.NoError(t, client.InitService(svc))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = svc.Wait(ctx, &GetByIdReq{})
+	}()
+	<-block.started
+
+	// 名额用完了，新的请求直接被拒绝
+	_, err = svc.Wait(context.Background(), &GetByIdReq{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 取消帧照样能读到，名额也就释放了
+	cancel()
+	select {
+	case err = <-block.result:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("服务端的方法没有被取消")
+	}
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, er := svc.Wait(ctx, &GetByIdReq{})
+		return status.Code(er) != codes.ResourceExhausted
+	}, time.Second, 10*time.Millisecond)
+}
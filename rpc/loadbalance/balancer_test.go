@@ -0,0 +1,84 @@
+package loadbalance
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"web/micro/registry"
+)
+
+func TestBalancer_Pick(t *testing.T) {
+	instances := []registry.ServiceInstance{
+		{Address: "a", Weight: 3},
+		{Address: "b", Weight: 1},
+		{Address: "c"},
+	}
+	testCases := []struct {
+		name     string
+		balancer Balancer
+		// 连续选择的结果
+		wantAddrs []string
+	}{
+		{
+			name:      "round robin",
+			balancer:  NewRoundRobin(),
+			wantAddrs: []string{"a", "b", "c", "a", "b"},
+		},
+		{
+			name:      "weighted",
+			balancer:  NewWeightedRoundRobin(),
+			wantAddrs: []string{"a", "b", "a", "c", "a", "a", "b", "a", "c", "a"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addrs := make([]string, 0, len(tc.wantAddrs))
+			for range tc.wantAddrs {
+				ins, done, err := tc.balancer.Pick(context.Background(), instances)
+				require.NoError(t, err)
+				done(nil)
+				addrs = append(addrs, ins.Address)
+			}
+			assert.Equal(t, tc.wantAddrs, addrs)
+		})
+	}
+}
+
+func TestBalancer_NoAvailable(t *testing.T) {
+	balancers := []Balancer{NewRoundRobin(), NewRandom(), NewWeightedRoundRobin(), NewLeastActive()}
+	for _, b := range balancers {
+		_, _, err := b.Pick(context.Background(), nil)
+		assert.Equal(t, ErrNoAvailable, err)
+	}
+}
+
+func TestLeastActive_Pick(t *testing.T) {
+	instances := []registry.ServiceInstance{{Address: "a"}, {Address: "b"}}
+	b := NewLeastActive()
+	first, done, err := b.Pick(context.Background(), instances)
+	require.NoError(t, err)
+	// 第一个还没有结束，第二次一定选另外一个
+	second, _, err := b.Pick(context.Background(), instances)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Address, second.Address)
+	// 第一个结束之后，它的活跃请求数最少
+	done(nil)
+	third, _, err := b.Pick(context.Background(), instances)
+	require.NoError(t, err)
+	assert.Equal(t, first.Address, third.Address)
+}
+
+func TestLeastActive_Remove(t *testing.T) {
+	b := NewLeastActive()
+	ins, done, err := b.Pick(context.Background(), []registry.ServiceInstance{{Address: "a"}})
+	require.NoError(t, err)
+	b.Remove(ins.Address)
+	assert.Empty(t, b.active)
+	// 删掉之后才结束的调用，不会影响新的计数器
+	done(nil)
+	_, _, err = b.Pick(context.Background(), []registry.ServiceInstance{{Address: "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *b.active["a"])
+}
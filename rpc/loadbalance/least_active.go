@@ -0,0 +1,66 @@
+package loadbalance
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"web/micro/registry"
+)
+
+// LeastActive 选择正在处理的请求最少的实例，一样少的时候随机选一个
+type LeastActive struct {
+	mutex sync.Mutex
+	// key 是实例地址
+	active map[string]*int64
+}
+
+func NewLeastActive() *LeastActive {
+	return &LeastActive{active: make(map[string]*int64, 8)}
+}
+
+func (l *LeastActive) Pick(ctx context.Context, instances []registry.ServiceInstance) (registry.ServiceInstance, DoneFunc, error) {
+	if len(instances) == 0 {
+		return registry.ServiceInstance{}, nil, ErrNoAvailable
+	}
+	l.mutex.Lock()
+	var (
+		least      int64 = -1
+		candidates []int
+	)
+	for i, ins := range instances {
+		cnt := atomic.LoadInt64(l.counter(ins.Address))
+		switch {
+		case least == -1 || cnt < least:
+			least = cnt
+			candidates = append(candidates[:0], i)
+		case cnt == least:
+			candidates = append(candidates, i)
+		}
+	}
+	picked := instances[candidates[rand.Intn(len(candidates))]]
+	counter := l.counter(picked.Address)
+	l.mutex.Unlock()
+
+	atomic.AddInt64(counter, 1)
+	return picked, func(err error) {
+		atomic.AddInt64(counter, -1)
+	}, nil
+}
+
+// Remove 还没有结束的调用结束的时候，减的是已经删掉的计数器，不影响重新上线的实例
+func (l *LeastActive) Remove(addr string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.active, addr)
+}
+
+// counter 需要持有锁
+func (l *LeastActive) counter(addr string) *int64 {
+	cnt, ok := l.active[addr]
+	if !ok {
+		cnt = new(int64)
+		l.active[addr] = cnt
+	}
+	return cnt
+}
@@ -0,0 +1,21 @@
+package loadbalance
+
+import (
+	"context"
+	"math/rand"
+	"web/micro/registry"
+)
+
+// Random 随机
+type Random struct{}
+
+func NewRandom() *Random {
+	return &Random{}
+}
+
+func (r *Random) Pick(ctx context.Context, instances []registry.ServiceInstance) (registry.ServiceInstance, DoneFunc, error) {
+	if len(instances) == 0 {
+		return registry.ServiceInstance{}, nil, ErrNoAvailable
+	}
+	return instances[rand.Intn(len(instances))], noopDone, nil
+}
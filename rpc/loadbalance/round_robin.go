@@ -0,0 +1,24 @@
+package loadbalance
+
+import (
+	"context"
+	"sync/atomic"
+	"web/micro/registry"
+)
+
+// RoundRobin 轮询
+type RoundRobin struct {
+	index uint32
+}
+
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{}
+}
+
+func (r *RoundRobin) Pick(ctx context.Context, instances []registry.ServiceInstance) (registry.ServiceInstance, DoneFunc, error) {
+	if len(instances) == 0 {
+		return registry.ServiceInstance{}, nil, ErrNoAvailable
+	}
+	idx := atomic.AddUint32(&r.index, 1) - 1
+	return instances[idx%uint32(len(instances))], noopDone, nil
+}
@@ -0,0 +1,28 @@
+package loadbalance
+
+import (
+	"context"
+	"web/micro/registry"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+// ErrNoAvailable 没有可用的实例
+var ErrNoAvailable = status.Error(codes.Unavailable, "micro: 没有可用的服务实例")
+
+// Balancer 每次调用的时候从所有实例里面选出一个
+type Balancer interface {
+	// Pick 选出一个实例，instances 不会被修改，为空的时候返回 ErrNoAvailable
+	// 返回的 done 在调用结束的时候调用，用于统计
+	Pick(ctx context.Context, instances []registry.ServiceInstance) (registry.ServiceInstance, DoneFunc, error)
+}
+
+// Remover 按照实例保存了状态的 Balancer 可以实现它，实例下线之后清理这个实例的状态
+type Remover interface {
+	Remove(addr string)
+}
+
+// DoneFunc 调用结束的回调，err 是调用的结果
+type DoneFunc func(err error)
+
+func noopDone(err error) {}
@@ -0,0 +1,63 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+	"web/micro/registry"
+)
+
+// WeightedRoundRobin 平滑的加权轮询，和 nginx 的算法一样
+// 权重为 3、1、1 的三个实例，选出来的顺序是 a a b a c 这种比较均匀的形式
+type WeightedRoundRobin struct {
+	mutex sync.Mutex
+	// key 是实例地址，记录当前的权重
+	current map[string]int
+}
+
+func NewWeightedRoundRobin() *WeightedRoundRobin {
+	return &WeightedRoundRobin{current: make(map[string]int, 8)}
+}
+
+func (w *WeightedRoundRobin) Pick(ctx context.Context, instances []registry.ServiceInstance) (registry.ServiceInstance, DoneFunc, error) {
+	if len(instances) == 0 {
+		return registry.ServiceInstance{}, nil, ErrNoAvailable
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	total := 0
+	best := -1
+	for i, ins := range instances {
+		weight := weightOf(ins)
+		total += weight
+		w.current[ins.Address] += weight
+		if best == -1 || w.current[ins.Address] > w.current[instances[best].Address] {
+			best = i
+		}
+	}
+	w.current[instances[best].Address] -= total
+	// 下线的实例不再参与计算
+	if len(w.current) > len(instances) {
+		w.cleanup(instances)
+	}
+	return instances[best], noopDone, nil
+}
+
+func (w *WeightedRoundRobin) cleanup(instances []registry.ServiceInstance) {
+	alive := make(map[string]struct{}, len(instances))
+	for _, ins := range instances {
+		alive[ins.Address] = struct{}{}
+	}
+	for addr := range w.current {
+		if _, ok := alive[addr]; !ok {
+			delete(w.current, addr)
+		}
+	}
+}
+
+// weightOf 没有设置权重的实例当作 1
+func weightOf(ins registry.ServiceInstance) int {
+	if ins.Weight <= 0 {
+		return 1
+	}
+	return int(ins.Weight)
+}
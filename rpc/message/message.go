@@ -1 +1,43 @@
 package message
+
+// Flag 的取值，按位组合
+// 普通的调用 Flag 是 0，流式调用的每一帧都带上 FlagStream，RequestId 就是流的 id
+const (
+	// FlagStream 这一帧属于某个流，只带这个标记的是打开流的第一帧
+	FlagStream uint8 = 1 << iota
+	// FlagData 这一帧带了一个消息
+	FlagData
+	// FlagHalfClose 发送方不会再发送消息了
+	// 服务端发送的时候表示流结束，Error 里面是结果
+	FlagHalfClose
+	// FlagReset 直接中断流
+	FlagReset
+	// FlagCancel 普通调用的客户端已经放弃了，服务端取消 RequestId 对应的请求
+	FlagCancel
+	// FlagWindow 流的接收方处理完了一些消息，Data 是 4 字节的数量，发送方可以再发送这么多
+	FlagWindow
+)
+
+const (
+	// VersionLegacy 最初的协议，头部定长部分是 15 字节，没有 Flag
+	VersionLegacy uint8 = 0
+	// VersionFlag 从这个版本开始，头部定长部分多了 1 字节的 Flag
+	// 普通调用仍然使用旧的格式，只有带 Flag 的帧才使用新的格式，旧版本的对端照样可以通信
+	VersionFlag uint8 = 1
+)
+
+// headerLength 头部定长部分的长度
+func headerLength(version uint8) int {
+	if version < VersionFlag {
+		return 15
+	}
+	return 16
+}
+
+// upgradeVersion 带 Flag 的帧至少要使用 VersionFlag
+func upgradeVersion(version, flag uint8) uint8 {
+	if flag != 0 && version < VersionFlag {
+		return VersionFlag
+	}
+	return version
+}
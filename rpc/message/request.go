@@ -18,7 +18,9 @@ type Request struct {
 	// 压缩算法
 	Compresser uint8
 	// 序列化协议：用来标识客户端和服务端用的是什么序列化方法
-	Serializer  uint8
+	Serializer uint8
+	// 标记位，流式调用使用
+	Flag        uint8
 	ServiceName string
 	MethodName  string
 	// 拓展字段，用于传输自定义数据，比如traceId等等
@@ -27,8 +29,10 @@ type Request struct {
 	Data []byte
 }
 
+// CalculateHeadLength 要在 Flag 设置好之后调用，带 Flag 的请求会升级 Version
 func (req *Request) CalculateHeadLength() {
-	headLength := 15 + len(req.ServiceName) + 1 + len(req.MethodName) + 1
+	req.Version = upgradeVersion(req.Version, req.Flag)
+	headLength := headerLength(req.Version) + len(req.ServiceName) + 1 + len(req.MethodName) + 1
 	for key, value := range req.Meta {
 		headLength += len(key)
 		headLength++
@@ -54,8 +58,12 @@ func EncodeReq(req *Request) []byte {
 	bs[12] = req.Version
 	bs[13] = req.Compresser
 	bs[14] = req.Serializer
+	fixed := headerLength(req.Version)
+	if fixed > 15 {
+		bs[15] = req.Flag
+	}
 	// 对于不定长的，我们使用copy
-	cur := bs[15:]
+	cur := bs[fixed:]
 	copy(cur, req.ServiceName)
 
 	cur = cur[len(req.ServiceName):]
@@ -95,11 +103,15 @@ func DecodeReq(data []byte) *Request {
 	req.Version = data[12]
 	req.Compresser = data[13]
 	req.Serializer = data[14]
+	fixed := headerLength(req.Version)
+	if fixed > 15 {
+		req.Flag = data[15]
+	}
 
 	// 为了解决不定长内容，所以我们需要引入分隔符
 	//req.ServiceName = string(data[15:])
 	// 将header和data分隔开
-	header := data[15:req.HeadLength]
+	header := data[fixed:req.HeadLength]
 	// MethodName的前面
 	index := bytes.IndexByte(header, '\n')
 	req.ServiceName = string(header[:index])
@@ -41,6 +41,16 @@ func TestEnDecode(t *testing.T) {
 				Data: []byte("hello \n world"),
 			},
 		},
+		{
+			name: "stream",
+			req: &Request{
+				RequestId:  123,
+				Version:    12,
+				Serializer: 14,
+				Flag:       FlagStream | FlagData,
+				Data:       []byte("hello world"),
+			},
+		},
 		{
 			name: "no meta",
 			req: &Request{
@@ -65,3 +75,34 @@ func TestEnDecode(t *testing.T) {
 		})
 	}
 }
+
+// TestLegacyLayout 普通调用仍然是 15 字节的定长头部，旧版本的对端可以正常解析
+func TestLegacyLayout(t *testing.T) {
+	req := &Request{
+		RequestId:   1,
+		Serializer:  1,
+		ServiceName: "user-service",
+		MethodName:  "GetById",
+		Data:        []byte("hello"),
+	}
+	req.CalculateHeadLength()
+	req.CalculateBodyLength()
+	data := EncodeReq(req)
+	assert.Equal(t, VersionLegacy, data[12])
+	assert.Equal(t, "user-service\nGetById\n", string(data[15:req.HeadLength]))
+	assert.Equal(t, req, DecodeReq(data))
+
+	resp := &Response{RequestId: 1, Serializer: 1, Data: []byte("hello")}
+	resp.CalculateHeadLength()
+	resp.CalculateBodyLength()
+	data = EncodeResp(resp)
+	assert.Equal(t, uint32(15), resp.HeadLength)
+	assert.Equal(t, "hello", string(data[15:]))
+	assert.Equal(t, resp, DecodeResp(data))
+
+	// 带 Flag 的帧升级到新的格式
+	frame := &Request{RequestId: 1, Flag: FlagCancel}
+	frame.CalculateHeadLength()
+	assert.Equal(t, VersionFlag, frame.Version)
+	assert.Equal(t, frame.Flag, DecodeReq(EncodeReq(frame)).Flag)
+}
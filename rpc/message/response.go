@@ -13,12 +13,16 @@ type Response struct {
 	Compresser uint8
 	// 序列化协议
 	Serializer uint8
-	Error      []byte
-	Data       []byte
+	// 标记位，流式调用使用
+	Flag  uint8
+	Error []byte
+	Data  []byte
 }
 
+// CalculateHeadLength 要在 Flag 设置好之后调用，带 Flag 的响应会升级 Version
 func (resp *Response) CalculateHeadLength() {
-	header := 15 + len(resp.Error)
+	resp.Version = upgradeVersion(resp.Version, resp.Flag)
+	header := headerLength(resp.Version) + len(resp.Error)
 	resp.HeadLength = uint32(header)
 }
 
@@ -38,8 +42,12 @@ func EncodeResp(resp *Response) []byte {
 	bs[12] = resp.Version
 	bs[13] = resp.Compresser
 	bs[14] = resp.Serializer
+	fixed := headerLength(resp.Version)
+	if fixed > 15 {
+		bs[15] = resp.Flag
+	}
 	// 对于不定长的，我们使用copy
-	cur := bs[15:]
+	cur := bs[fixed:]
 
 	copy(cur, resp.Error)
 
@@ -58,9 +66,13 @@ func DecodeResp(data []byte) *Response {
 	resp.Version = data[12]
 	resp.Compresser = data[13]
 	resp.Serializer = data[14]
+	fixed := uint32(headerLength(resp.Version))
+	if fixed > 15 {
+		resp.Flag = data[15]
+	}
 
-	if resp.HeadLength > 15 {
-		resp.Error = data[15:resp.HeadLength]
+	if resp.HeadLength > fixed {
+		resp.Error = data[fixed:resp.HeadLength]
 	}
 
 	if resp.BodyLength != 0 {
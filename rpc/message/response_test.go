@@ -31,6 +31,16 @@ func TestRespEnDecode(t *testing.T) {
 				Data:       []byte("hello world"),
 			},
 		},
+		{
+			name: "stream",
+			resp: &Response{
+				RequestId:  123,
+				Version:    12,
+				Serializer: 14,
+				Flag:       FlagStream | FlagHalfClose,
+				Error:      []byte("Error"),
+			},
+		},
 		{
 			name: "no data",
 			resp: &Response{
@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/status"
+)
+
+// metaIdempotent 请求的 Meta 里面带上这个 key，值为 true 的时候表示幂等
+const metaIdempotent = "idempotent"
+
+// RetryPolicy 重试策略，只有幂等的调用才会重试，oneway 调用从来不重试
+type RetryPolicy struct {
+	// MaxAttempts 最多调用几次，包括第一次，小于 2 的时候不重试
+	MaxAttempts int
+	// InitialBackoff 第一次重试之前等待的时间，之后每次乘以 Multiplier，不超过 MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter 等待时间上下随机浮动的比例，取值 0 到 1，避免大量的客户端同时重试
+	Jitter float64
+	// RetryableCodes 哪些错误码可以重试，为空的时候只重试 Unavailable
+	RetryableCodes []codes.Code
+	// Idempotent 方法是幂等的，所有的调用都可以重试
+	// 为 false 的时候，只有 CtxWithIdempotent 标记过的调用才会重试
+	Idempotent bool
+}
+
+// backoff 第 attempt 次重试之前等待的时间，attempt 从 1 开始
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+	return time.Duration(backoff)
+}
+
+func (p RetryPolicy) retryable(code codes.Code) bool {
+	if len(p.RetryableCodes) == 0 {
+		return code == codes.Unavailable
+	}
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientWithRetryPolicy 设置重试策略
+// methodName 为空的时候对整个服务生效，serviceName 也为空的时候对所有的调用生效，越具体的优先级越高
+func ClientWithRetryPolicy(serviceName, methodName string, policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		if c.retryPolicies == nil {
+			c.retryPolicies = make(map[string]RetryPolicy, 4)
+		}
+		c.retryPolicies[serviceName+"/"+methodName] = policy
+	}
+}
+
+// ClientWithRetryThrottle 限制重试的比例，避免服务端出问题的时候重试把它压垮
+// 令牌桶最多 maxTokens 个，每次失败减 1，每次成功加 ratio，令牌少于一半的时候不再重试
+func ClientWithRetryThrottle(maxTokens, ratio float64) ClientOption {
+	return func(c *Client) {
+		c.retryThrottle = &retryThrottle{maxTokens: maxTokens, tokens: maxTokens, ratio: ratio}
+	}
+}
+
+func (c *Client) retryPolicy(req *message.Request) (RetryPolicy, bool) {
+	for _, key := range []string{req.ServiceName + "/" + req.MethodName, req.ServiceName + "/", "/"} {
+		if p, ok := c.retryPolicies[key]; ok {
+			return p, true
+		}
+	}
+	return RetryPolicy{}, false
+}
+
+// invokeWithRetry 按照重试策略调用，返回最后一次的结果
+// 服务端返回的错误在 resp.Error 里面，也要检查错误码
+func (c *Client) invokeWithRetry(ctx context.Context, req *message.Request,
+	invoke func(ctx context.Context, req *message.Request) (*message.Response, error)) (*message.Response, error) {
+	policy, ok := c.retryPolicy(req)
+	if !ok || policy.MaxAttempts < 2 || isOneway(ctx) ||
+		!(policy.Idempotent || req.Meta[metaIdempotent] == "true") {
+		return invoke(ctx, req)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := invoke(ctx, req)
+		code := status.Code(err)
+		if err == nil && len(resp.Error) > 0 {
+			code = status.Decode(resp.Error).Code
+		}
+		if !policy.retryable(code) {
+			c.retryThrottle.success()
+			return resp, err
+		}
+		if !c.retryThrottle.fail() || attempt >= policy.MaxAttempts {
+			return resp, err
+		}
+		backoff := policy.backoff(attempt)
+		// 剩下的时间不够等待的话，就不用再试了
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= backoff {
+			return resp, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		}
+	}
+}
+
+// retryThrottle 和 gRPC 的重试限流一样的令牌桶，为 nil 的时候不限制
+type retryThrottle struct {
+	mutex     sync.Mutex
+	maxTokens float64
+	tokens    float64
+	ratio     float64
+}
+
+func (t *retryThrottle) success() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tokens = math.Min(t.tokens+t.ratio, t.maxTokens)
+}
+
+// fail 返回是否还允许重试
+func (t *retryThrottle) fail() bool {
+	if t == nil {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tokens = math.Max(t.tokens-1, 0)
+	return t.tokens > t.maxTokens/2
+}
@@ -0,0 +1,281 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+func TestClient_Retry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+	idempotent := policy
+	idempotent.Idempotent = true
+
+	testCases := []struct {
+		name string
+		opts []ClientOption
+		ctx  func() context.Context
+		// 前几次调用返回 Unavailable
+		failures  int32
+		wantCode  codes.Code
+		wantCalls int32
+	}{
+		{
+			name:      "no policy",
+			failures:  1,
+			wantCode:  codes.Unavailable,
+			wantCalls: 1,
+		},
+		{
+			name:      "not idempotent",
+			opts:      []ClientOption{ClientWithRetryPolicy("flaky-service", "", policy)},
+			failures:  1,
+			wantCode:  codes.Unavailable,
+			wantCalls: 1,
+		},
+		{
+			name:      "idempotent method",
+			opts:      []ClientOption{ClientWithRetryPolicy("flaky-service", "GetById", idempotent)},
+			failures:  2,
+			wantCode:  codes.OK,
+			wantCalls: 3,
+		},
+		{
+			name: "idempotent ctx",
+			opts: []ClientOption{ClientWithRetryPolicy("", "", policy)},
+			ctx: func() context.Context {
+				return CtxWithIdempotent(context.Background())
+			},
+			failures:  1,
+			wantCode:  codes.OK,
+			wantCalls: 2,
+		},
+		{
+			name:      "max attempts",
+			opts:      []ClientOption{ClientWithRetryPolicy("flaky-service", "", idempotent)},
+			failures:  5,
+			wantCode:  codes.Unavailable,
+			wantCalls: 3,
+		},
+		{
+			name: "most specific policy",
+			opts: []ClientOption{
+				ClientWithRetryPolicy("flaky-service", "", idempotent),
+				ClientWithRetryPolicy("flaky-service", "GetById", RetryPolicy{}),
+			},
+			failures:  1,
+			wantCode:  codes.Unavailable,
+			wantCalls: 1,
+		},
+		{
+			name: "code not retryable",
+			opts: []ClientOption{ClientWithRetryPolicy("flaky-service", "", RetryPolicy{
+				MaxAttempts:    3,
+				Idempotent:     true,
+				RetryableCodes: []codes.Code{codes.ResourceExhausted},
+			})},
+			failures:  1,
+			wantCode:  codes.Unavailable,
+			wantCalls: 1,
+		},
+		{
+			name: "deadline",
+			opts: []ClientOption{ClientWithRetryPolicy("flaky-service", "", RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Second,
+				Idempotent:     true,
+			})},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+				t.Cleanup(cancel)
+				return ctx
+			},
+			failures:  1,
+			wantCode:  codes.Unavailable,
+			wantCalls: 1,
+		},
+		{
+			name: "throttle",
+			opts: []ClientOption{
+				ClientWithRetryPolicy("flaky-service", "", idempotent),
+				// 失败一次之后令牌就只剩一半了
+				ClientWithRetryThrottle(2, 0.1),
+			},
+			failures:  5,
+			wantCode:  codes.Unavailable,
+			wantCalls: 1,
+		},
+		{
+			name: "oneway",
+			opts: []ClientOption{ClientWithRetryPolicy("flaky-service", "", idempotent)},
+			ctx: func() context.Context {
+				return CtxWithOneway(context.Background())
+			},
+			failures:  1,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &flakyService{failures: tc.failures}
+			server := NewServer()
+			require.NoError(t, server.RegisterService(service))
+			client, err := NewClient(startTestServer(t, server), tc.opts...)
+			require.NoError(t, err)
+			defer client.Close()
+			svc := &FlakyService{}
+			require.NoError(t, client.InitService(svc))
+
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx()
+			}
+			_, err = svc.GetById(ctx, &GetByIdReq{})
+			if isOneway(ctx) {
+				// oneway 调用不等结果，等服务端处理完
+				time.Sleep(50 * time.Millisecond)
+			} else {
+				assert.Equal(t, tc.wantCode, status.Code(err))
+			}
+			assert.Equal(t, tc.wantCalls, atomic.LoadInt32(&service.calls))
+		})
+	}
+}
+
+type FlakyService struct {
+	GetById func(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
+}
+
+func (f *FlakyService) Name() string {
+	return "flaky-service"
+}
+
+// flakyService 前 failures 次调用返回 Unavailable
+type flakyService struct {
+	failures int32
+	calls    int32
+}
+
+func (f *flakyService) Name() string {
+	return "flaky-service"
+}
+
+func (f *flakyService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	if atomic.AddInt32(&f.calls, 1) <= f.failures {
+		return nil, status.Error(codes.Unavailable, "服务暂时不可用")
+	}
+	return &GetByIdResp{Msg: "ok"}, nil
+}
+
+// TestClient_RetryConnDropped 调用的过程中连接断开，幂等的调用会重试
+func TestClient_RetryConnDropped(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []ClientOption
+		wantCode codes.Code
+		// 重试的时候会建立新的连接
+		wantConns int32
+	}{
+		{
+			name:      "no policy",
+			wantCode:  codes.Unavailable,
+			wantConns: 1,
+		},
+		{
+			name: "retry",
+			opts: []ClientOption{ClientWithRetryPolicy("flaky-service", "", RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Millisecond,
+				Idempotent:     true,
+			})},
+			wantCode:  codes.OK,
+			wantConns: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			listener := &dropListener{Listener: l}
+			server := NewServer()
+			require.NoError(t, server.RegisterService(&dropService{listener: listener}))
+			go func() {
+				_ = server.Serve(listener)
+			}()
+			defer server.Close()
+
+			client, err := NewClient(l.Addr().String(), tc.opts...)
+			require.NoError(t, err)
+			defer client.Close()
+			svc := &FlakyService{}
+			require.NoError(t, client.InitService(svc))
+			_, err = svc.GetById(context.Background(), &GetByIdReq{})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantConns, listener.accepted())
+		})
+	}
+}
+
+// dropListener 记录接受的连接，可以把它们全部断开
+type dropListener struct {
+	net.Listener
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func (l *dropListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	l.conns = append(l.conns, conn)
+	l.mutex.Unlock()
+	return conn, nil
+}
+
+func (l *dropListener) accepted() int32 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int32(len(l.conns))
+}
+
+func (l *dropListener) drop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+// dropService 第一次调用的时候断开所有的连接
+type dropService struct {
+	listener *dropListener
+	calls    int32
+}
+
+func (d *dropService) Name() string {
+	return "flaky-service"
+}
+
+func (d *dropService) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	if atomic.AddInt32(&d.calls, 1) == 1 {
+		d.listener.drop()
+	}
+	return &GetByIdResp{Msg: "ok"}, nil
+}
@@ -4,41 +4,137 @@ import (
 	"context"
 	"errors"
 	"net"
-	"reflect"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+	"web/micro/health"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/compress"
+	"web/micro/rpc/compress/gzip"
+	"web/micro/rpc/compress/snappy"
+	"web/micro/rpc/compress/zlib"
 	"web/micro/rpc/message"
 	"web/micro/rpc/serialize"
 	"web/micro/rpc/serialize/json"
+	"web/micro/rpc/status"
 )
 
 type Server struct {
-	services map[string]reflectionStub
+	services map[string]stub
 	// 不考虑做成这样，因为客户端的可能是确定的，但是一个服务端可能会有多个客户端
 	// 所以服务端可能会有多个serialize序列化协议
 	// serializer serialize.Serialize
 	serializers map[uint8]serialize.Serialize
+	// 和序列化协议一样，不同的客户端可能使用不同的压缩算法
+	compressors map[uint8]compress.Compressor
+	// 响应小于这个大小的时候不压缩
+	compressThreshold int
+	// 为 nil 的时候没有拦截器
+	interceptor ServerInterceptor
+	health      *health.Server
+	// 为 0 的时候不限制
+	maxConns        int
+	maxConnRequests int
+
+	// 保护下面的字段，Shutdown 和 Close 的时候要关闭它们
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	closed    bool
+	// 还在后台执行的 oneway 方法，它们不占用连接，Shutdown 要单独等待
+	oneway int32
 }
 
-func NewServer() *Server {
+// ErrServerClosed 调用了 Shutdown 或者 Close 之后，Start 和 Serve 返回这个错误
+var ErrServerClosed = errors.New("micro: 服务端已关闭")
+
+var (
+	errTooManyConns    = status.Error(codes.ResourceExhausted, "micro: 连接数超过上限")
+	errTooManyRequests = status.Error(codes.ResourceExhausted, "micro: 连接上正在处理的请求数超过上限")
+)
+
+// defaultMaxConnRequests 默认每个连接上最多同时处理的请求数
+const defaultMaxConnRequests = 1000
+
+// rejectTimeout 超过连接数上限的时候，等待第一个请求的时间
+const rejectTimeout = time.Second
+
+// shutdownPollInterval Shutdown 检查连接是否空闲的间隔
+const shutdownPollInterval = 50 * time.Millisecond
+
+type ServerOption func(*Server)
+
+func NewServer(opts ...ServerOption) *Server {
 	res := &Server{
-		services:    make(map[string]reflectionStub, 16),
-		serializers: make(map[uint8]serialize.Serialize, 4),
+		services:          make(map[string]stub, 16),
+		serializers:       make(map[uint8]serialize.Serialize, 4),
+		compressors:       make(map[uint8]compress.Compressor, 4),
+		compressThreshold: defaultCompressThreshold,
+		maxConnRequests:   defaultMaxConnRequests,
+		listeners:         make(map[net.Listener]struct{}, 1),
+		conns:             make(map[*serverConn]struct{}, 16),
+		health:            health.NewServer(),
 	}
 	res.RegisterSerializer(&json.Serializer{})
+	// 内置的压缩算法默认都支持，由客户端决定用哪一个
+	res.RegisterCompressor(&gzip.Compressor{})
+	res.RegisterCompressor(&zlib.Compressor{})
+	res.RegisterCompressor(&snappy.Compressor{})
+	for _, opt := range opts {
+		opt(res)
+	}
+	// 内置的健康检查服务一定能注册成功
+	_ = res.RegisterService(&healthService{s: res.health})
 	return res
 }
 
+// ServerWithCompressThreshold 设置压缩的阈值，响应的 Data 小于 threshold 字节的时候不压缩
+func ServerWithCompressThreshold(threshold int) ServerOption {
+	return func(s *Server) {
+		s.compressThreshold = threshold
+	}
+}
+
+// ServerWithInterceptors 设置服务端拦截器，可以多次调用，按照添加的顺序执行
+func ServerWithInterceptors(interceptors ...ServerInterceptor) ServerOption {
+	return func(s *Server) {
+		if s.interceptor != nil {
+			interceptors = append([]ServerInterceptor{s.interceptor}, interceptors...)
+		}
+		s.interceptor = ChainServerInterceptors(interceptors...)
+	}
+}
+
 func (s *Server) RegisterSerializer(sl serialize.Serialize) {
 	s.serializers[sl.Code()] = sl
 }
 
-func (s *Server) RegisterService(service Service) {
-	s.services[service.Name()] = reflectionStub{
-		s:           service,
-		serializers: s.serializers,
-		value:       reflect.ValueOf(service),
+func (s *Server) RegisterCompressor(c compress.Compressor) {
+	s.compressors[c.Code()] = c
+}
+
+// Health 通过它设置健康状态，客户端可以通过 HealthService 查询
+// 注册的服务默认是 SERVING，Shutdown 和 Close 的时候全部变成 NOT_SERVING
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// RegisterService 注册服务，注册的时候就把所有方法解析好，调用的时候不需要再反射查找
+// 除了 Name 以外，所有公开方法都必须是 func(context.Context, *Req) (*Resp, error) 的形式
+// 实现了 Dispatcher 的服务（比如 micro-gen 生成的）不走反射
+func (s *Server) RegisterService(service Service) error {
+	if d, ok := service.(Dispatcher); ok {
+		s.services[service.Name()] = &dispatcherStub{d: d, serializers: s.serializers}
+		s.health.SetServingStatus(service.Name(), health.StatusServing)
+		return nil
 	}
+	stub, err := newReflectionStub(service, s.serializers)
+	if err != nil {
+		return err
+	}
+	s.services[service.Name()] = stub
+	s.health.SetServingStatus(service.Name(), health.StatusServing)
+	return nil
 }
 
 func (s *Server) Start(network, addr string) error {
@@ -46,84 +142,481 @@ func (s *Server) Start(network, addr string) error {
 	if err != nil {
 		return err
 	}
+	return s.Serve(listener)
+}
+
+// Serve 在已有的 listener 上提供服务，比如测试的时候监听 127.0.0.1:0
+// 调用了 Shutdown 或者 Close 之后返回 ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.listeners, listener)
+		s.mutex.Unlock()
+	}()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
 			return err
 		}
-		go func() {
-			if er := s.handleConn(conn); er != nil {
+		sc := newServerConn(conn)
+		if s.maxConnRequests > 0 {
+			sc.slots = make(chan struct{}, s.maxConnRequests)
+		}
+		if err = s.trackConn(sc); err != nil {
+			sc.cancel()
+			if errors.Is(err, ErrServerClosed) {
 				_ = conn.Close()
+				return err
 			}
+			go s.rejectConn(sc)
+			continue
+		}
+		go func() {
+			_ = s.handleConn(sc)
+			_ = conn.Close()
+			s.untrackConn(sc)
 		}()
 	}
 }
 
-func (s *Server) handleConn(conn net.Conn) error {
+// Shutdown 优雅退出：不再接收新连接，等正在处理的请求结束之后关闭连接，后台执行的 oneway 方法也要等它们结束
+// ctx 过期的时候直接返回 ctx.Err()，剩下的连接可以再调用 Close 强制关闭
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	s.mutex.Lock()
+	s.closed = true
+	err := s.closeListenersLocked()
+	s.mutex.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
 	for {
-		// 读取响应
-		resBs, err := ReadMsg(conn)
-		if err != nil {
+		if s.closeIdleConns() && atomic.LoadInt32(&s.oneway) == 0 {
 			return err
 		}
-
-		req := message.DecodeReq(resBs)
-		if err != nil {
-			return err
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
-		ctx := context.Background()
-		cancel := func() {}
-		oneway, ok := req.Meta["one-way"]
-		if ok && oneway == "true" {
-			ctx = CtxWithOneway(ctx)
+	}
+}
+
+// Close 立刻关闭所有的 listener 和连接，不等待正在处理的请求
+func (s *Server) Close() error {
+	s.health.Shutdown()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	err := s.closeListenersLocked()
+	for sc := range s.conns {
+		_ = sc.Close()
+		delete(s.conns, sc)
+	}
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for l := range s.listeners {
+		if er := l.Close(); er != nil && err == nil {
+			err = er
 		}
-		deadlineStr, ok := req.Meta["deadline"]
-		if ok && deadlineStr != "" {
-			if deadline, er := strconv.ParseInt(deadlineStr, 10, 64); er == nil {
-				ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
-			}
+		delete(s.listeners, l)
+	}
+	return err
+}
+
+// closeIdleConns 关闭没有请求在处理的连接，返回是否所有连接都已经关闭
+func (s *Server) closeIdleConns() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for sc := range s.conns {
+		if atomic.LoadInt32(&sc.active) == 0 {
+			_ = sc.Close()
+			delete(s.conns, sc)
 		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) trackConn(sc *serverConn) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		return errTooManyConns
+	}
+	s.conns[sc] = struct{}{}
+	return nil
+}
+
+func (s *Server) untrackConn(sc *serverConn) {
+	s.mutex.Lock()
+	delete(s.conns, sc)
+	s.mutex.Unlock()
+}
+
+// serverConn 记录连接上还有多少请求在处理，Shutdown 只关闭空闲的连接
+type serverConn struct {
+	net.Conn
+	active int32
+	// 同一个连接上的请求并发处理，响应可能乱序写回，所以写的时候要加锁
+	// 客户端依靠 RequestId 把响应和请求对应起来
+	writeMutex sync.Mutex
+	// 还没有结束的流，只有读协程会增加，方法返回的时候删除
+	streamMutex sync.Mutex
+	streams     map[uint32]*serverStream
+	// 限制同时处理的请求数，为 nil 的时候不限制
+	slots chan struct{}
+	// 连接断开的时候取消，正在处理的请求的 ctx 都从它派生
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 正在处理的普通请求，key 是 RequestId
+	reqMutex sync.Mutex
+	reqs     map[uint32]context.CancelFunc
+}
+
+func newServerConn(conn net.Conn) *serverConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &serverConn{
+		Conn:    conn,
+		streams: make(map[uint32]*serverStream, 4),
+		ctx:     ctx,
+		cancel:  cancel,
+		reqs:    make(map[uint32]context.CancelFunc, 16),
+	}
+}
+
+// acquire 占用一个处理请求的名额，没有名额的时候返回 false
+func (c *serverConn) acquire() bool {
+	if c.slots == nil {
+		return true
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *serverConn) release() {
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+func (c *serverConn) trackReq(id uint32) context.Context {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.reqMutex.Lock()
+	c.reqs[id] = cancel
+	c.reqMutex.Unlock()
+	return ctx
+}
 
-		resp, err := s.Invoke(ctx, req)
-		// 调用结束后，就可以cancel掉了
+func (c *serverConn) untrackReq(id uint32) {
+	c.reqMutex.Lock()
+	cancel, ok := c.reqs[id]
+	delete(c.reqs, id)
+	c.reqMutex.Unlock()
+	if ok {
 		cancel()
-		if err != nil {
-			resp.Error = []byte(err.Error())
-			// 不return，只要连接还正常就继续通信
-			//return nil
-		}
-		resp.CalculateHeadLength()
-		resp.CalculateBodyLength()
-		data := message.EncodeResp(resp)
-		// 回写请求
-		n, err := conn.Write(data)
+	}
+}
+
+// cancelReq 客户端放弃了这个请求，请求已经结束的时候什么也不做
+func (c *serverConn) cancelReq(id uint32) {
+	c.reqMutex.Lock()
+	cancel, ok := c.reqs[id]
+	c.reqMutex.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (c *serverConn) write(resp *message.Response) error {
+	data := message.EncodeResp(resp)
+	c.writeMutex.Lock()
+	_, err := c.Write(data)
+	c.writeMutex.Unlock()
+	if err != nil {
+		// 写失败了连接也就不能用了，关掉之后读循环会退出
+		_ = c.Close()
+	}
+	return err
+}
+
+func (s *Server) handleConn(conn *serverConn) error {
+	defer s.resetStreams(conn)
+	// 连接断开了，客户端也就收不到结果了，取消所有正在处理的请求
+	defer conn.cancel()
+	for {
+		// 读取请求
+		reqBs, err := ReadMsg(conn)
 		if err != nil {
 			return err
 		}
-		if n != len(resBs) {
-			return errors.New("micro: 没写完数据")
+
+		req := message.DecodeReq(reqBs)
+		if req.Flag&message.FlagStream != 0 {
+			s.handleStreamFrame(conn, req)
+			continue
 		}
+		if req.Flag&message.FlagCancel != 0 {
+			conn.cancelReq(req.RequestId)
+			continue
+		}
+		// 读协程不能等，否则取消帧和流的帧都读不到了，超过上限的请求直接拒绝
+		if !conn.acquire() {
+			if req.Meta["one-way"] != "true" {
+				_ = conn.write(rejectResponse(req, 0, errTooManyRequests))
+			}
+			continue
+		}
+		atomic.AddInt32(&conn.active, 1)
+		// 在读协程里面登记，保证取消帧一定能找到请求
+		ctx := conn.trackReq(req.RequestId)
+		go func() {
+			defer func() {
+				conn.untrackReq(req.RequestId)
+				atomic.AddInt32(&conn.active, -1)
+				conn.release()
+			}()
+			resp := s.handleReq(ctx, req)
+			// oneway 调用不需要回写
+			if resp == nil {
+				return
+			}
+			_ = conn.write(resp)
+		}()
+	}
+}
+
+// rejectConn 超过连接数上限的连接，回复第一个请求 ResourceExhausted 之后关闭
+// 直接关闭的话客户端只能看到连接断开，不知道是服务端过载了
+func (s *Server) rejectConn(conn *serverConn) {
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(rejectTimeout))
+	reqBs, err := ReadMsg(conn)
+	if err != nil {
+		return
+	}
+	req := message.DecodeReq(reqBs)
+	switch {
+	case req.Flag == message.FlagStream:
+		// 打开流的第一帧，按照流结束的方式返回错误
+		_ = conn.write(rejectResponse(req, message.FlagStream|message.FlagHalfClose, errTooManyConns))
+	case req.Flag == 0 && req.Meta["one-way"] != "true":
+		_ = conn.write(rejectResponse(req, 0, errTooManyConns))
+	}
+}
+
+// rejectResponse 不调用方法，直接返回错误
+func rejectResponse(req *message.Request, flag uint8, err error) *message.Response {
+	resp := newResponse(req)
+	resp.Flag = flag
+	resp.Error = status.Encode(status.Convert(err))
+	resp.CalculateHeadLength()
+	resp.CalculateBodyLength()
+	return resp
+}
+
+// handleStreamFrame 处理流的帧，第一次见到的 id 就打开一个新的流
+func (s *Server) handleStreamFrame(conn *serverConn, req *message.Request) {
+	conn.streamMutex.Lock()
+	st, ok := conn.streams[req.RequestId]
+	conn.streamMutex.Unlock()
+	if ok {
+		st.onFrame(req)
+		return
+	}
+	// 流已经结束了，客户端发来的后续帧直接丢掉
+	if req.Flag != message.FlagStream {
+		return
+	}
+
+	// 和 handleReq 一样按照 Meta 里面的超时时间设置 ctx，客户端中断或者连接断开的时候取消
+	ctx, cancel := ctxWithTimeout(context.Background(), req.Meta)
+	st = &serverStream{
+		ctx:        ctx,
+		cancel:     cancel,
+		conn:       conn,
+		id:         req.RequestId,
+		code:       req.Serializer,
+		serializer: s.serializers[req.Serializer],
+		frames:     newFrameQueue[*message.Request](),
+		sendWindow: newSendWindow(),
+	}
+	var handler streamStub
+	if st.serializer == nil {
+		st.finish(errUnsupportedSerializer)
+		return
+	}
+	service, ok := s.services[req.ServiceName]
+	if ok {
+		handler, ok = service.(streamStub)
+	}
+	if !ok {
+		st.finish(ErrMethodNotFound(req.ServiceName, req.MethodName))
+		return
+	}
+
+	if !conn.acquire() {
+		st.finish(errTooManyRequests)
+		return
+	}
+	conn.streamMutex.Lock()
+	conn.streams[req.RequestId] = st
+	conn.streamMutex.Unlock()
+	atomic.AddInt32(&conn.active, 1)
+	go func() {
+		defer func() {
+			atomic.AddInt32(&conn.active, -1)
+			conn.release()
+		}()
+		err := s.invokeStream(ctx, req, handler, st)
+		conn.streamMutex.Lock()
+		delete(conn.streams, req.RequestId)
+		conn.streamMutex.Unlock()
+		st.finish(err)
+	}()
+}
+
+// invokeStream 流式方法也经过拦截器，整个流算一次调用，限流器在流结束之后才归还名额
+func (s *Server) invokeStream(ctx context.Context, req *message.Request, handler streamStub, st *serverStream) error {
+	next := func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		return nil, handler.invokeStream(ctx, req.MethodName, st)
+	}
+	if s.interceptor == nil {
+		_, err := next(ctx, req)
+		return err
+	}
+	_, err := s.interceptor(ctx, req, next)
+	return err
+}
+
+// resetStreams 连接断开了，中断上面所有的流
+func (s *Server) resetStreams(conn *serverConn) {
+	conn.streamMutex.Lock()
+	defer conn.streamMutex.Unlock()
+	for _, st := range conn.streams {
+		st.frames.close(errConnClosed)
+		st.cancel()
+	}
+}
+
+// handleReq 处理一个请求，返回 nil 说明不需要响应
+// ctx 在客户端发来取消帧或者连接断开的时候会被取消
+func (s *Server) handleReq(ctx context.Context, req *message.Request) *message.Response {
+	if req.Meta["one-way"] == "true" {
+		// oneway 的方法在后台执行，客户端不会等待结果，也就不会取消
+		ctx = CtxWithOneway(context.Background())
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = ctxWithTimeout(ctx, req.Meta)
+		defer cancel()
+	}
+
+	var resp *message.Response
+	// 客户端用什么算法压缩，响应也就用什么算法压缩
+	compressor, err := s.decompress(req)
+	if err == nil {
+		resp, err = s.Invoke(ctx, req)
+	}
+	if isOneway(ctx) {
+		return nil
+	}
+	// 解压失败，或者拦截器直接返回了
+	if resp == nil {
+		resp = newResponse(req)
+	}
+	// 拦截器自己构造的响应不一定带上了这些字段，客户端要靠 RequestId 找到等待的调用
+	resp.RequestId = req.RequestId
+	resp.Serializer = req.Serializer
+	if err != nil {
+		// 带上错误码，不认识的错误当作 Unknown
+		resp.Error = status.Encode(status.Convert(err))
+		// 不return，只要连接还正常就继续通信
 	}
+	s.compress(compressor, resp)
+	resp.CalculateHeadLength()
+	resp.CalculateBodyLength()
+	return resp
+}
+
+// decompress 解压请求的数据，返回请求所使用的压缩算法
+func (s *Server) decompress(req *message.Request) (compress.Compressor, error) {
+	if req.Compresser == 0 {
+		return nil, nil
+	}
+	c, ok := s.compressors[req.Compresser]
+	if !ok {
+		return nil, errUnsupportedCompressor
+	}
+	data, err := c.Decompress(req.Data)
+	if err != nil {
+		return nil, err
+	}
+	req.Data = data
+	req.CalculateBodyLength()
+	return c, nil
+}
+
+// compress 压缩响应的数据，压缩失败就直接发送原数据
+func (s *Server) compress(c compress.Compressor, resp *message.Response) {
+	resp.Compresser = 0
+	if c == nil || len(resp.Data) < s.compressThreshold {
+		return
+	}
+	data, err := c.Compress(resp.Data)
+	if err != nil {
+		return
+	}
+	resp.Data = data
+	resp.Compresser = c.Code()
 }
 
 func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Response, error) {
+	if s.interceptor != nil {
+		return s.interceptor(ctx, req, s.doInvoke)
+	}
+	return s.doInvoke(ctx, req)
+}
 
+func (s *Server) doInvoke(ctx context.Context, req *message.Request) (*message.Response, error) {
 	// 调用指定方法
 	service, ok := s.services[req.ServiceName]
-	resp := &message.Response{
-		RequestId:  req.RequestId,
-		Version:    req.Version,
-		Compresser: req.Compresser,
-		Serializer: req.Serializer,
-	}
+	resp := newResponse(req)
 
 	if !ok {
-		return resp, errors.New("rpc: 要调用的方法不存在")
+		return resp, status.Errorf(codes.NotFound, "rpc: 要调用的服务不存在 %s", req.ServiceName)
 	}
 
 	if isOneway(ctx) {
+		atomic.AddInt32(&s.oneway, 1)
 		go func() {
+			defer atomic.AddInt32(&s.oneway, -1)
 			_, _ = service.invoke(ctx, req)
 		}()
 		return nil, errors.New("micro: 微服务服务端收到 oneway 请求")
@@ -137,44 +630,11 @@ func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Res
 	return resp, nil
 }
 
-// reflectionStub 的出现是为了防止，如果以后可能会需要使用到unsafe
-type reflectionStub struct {
-	s Service
-	// 我们也不知道reflection要用哪个，所以全部传下来
-	//serializer serialize.Serialize
-	serializers map[uint8]serialize.Serialize
-	value       reflect.Value
-}
-
-func (s *reflectionStub) invoke(ctx context.Context, req *message.Request) ([]byte, error) {
-	method := s.value.MethodByName(req.MethodName)
-	in := make([]reflect.Value, 2)
-	in[0] = reflect.ValueOf(ctx)
-
-	inReq := reflect.New(method.Type().In(1).Elem())
-	serializer, ok := s.serializers[req.Serializer]
-	if !ok {
-		return nil, errors.New("micro: 不支持的序列化协议")
-	}
-	err := serializer.Decode(req.Data, inReq.Interface())
-	if err != nil {
-		return nil, err
-	}
-	in[1] = inReq
-	results := method.Call(in)
-
-	if results[1].Interface() != nil {
-		err = results[1].Interface().(error)
-	}
-	var res []byte
-	if results[0].IsNil() {
-		return nil, err
-	} else {
-		var er error
-		res, er = serializer.Encode(results[0].Interface())
-		if er != nil {
-			return nil, er
-		}
+func newResponse(req *message.Request) *message.Response {
+	return &message.Response{
+		RequestId:  req.RequestId,
+		Version:    req.Version,
+		Compresser: req.Compresser,
+		Serializer: req.Serializer,
 	}
-	return res, nil
 }
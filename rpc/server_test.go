@@ -0,0 +1,267 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/serialize"
+	"web/micro/rpc/serialize/json"
+	"web/micro/rpc/status"
+)
+
+func TestServer_RegisterService(t *testing.T) {
+	testCases := []struct {
+		name    string
+		service Service
+		wantErr bool
+	}{
+		{
+			name:    "normal",
+			service: &UserServiceServer{},
+		},
+		{
+			name:    "no context",
+			service: &noCtxService{},
+			wantErr: true,
+		},
+		{
+			name:    "no error",
+			service: &noErrService{},
+			wantErr: true,
+		},
+		{
+			name:    "nil",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewServer().RegisterService(tc.service)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
+
+func TestServer_Invoke(t *testing.T) {
+	server := NewServer()
+	require.NoError(t, server.RegisterService(&UserServiceServer{Msg: "hello"}))
+
+	testCases := []struct {
+		name     string
+		req      *message.Request
+		wantData string
+		wantCode codes.Code
+	}{
+		{
+			name: "normal",
+			req: &message.Request{
+				Serializer:  1,
+				ServiceName: "user-service",
+				MethodName:  "GetById",
+				Data:        []byte(`{"Id":123}`),
+			},
+			wantData: `{"Msg":"hello"}`,
+		},
+		{
+			name: "service not found",
+			req: &message.Request{
+				Serializer:  1,
+				ServiceName: "order-service",
+				MethodName:  "GetById",
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "method not found",
+			req: &message.Request{
+				Serializer:  1,
+				ServiceName: "user-service",
+				MethodName:  "Delete",
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "unsupported serializer",
+			req: &message.Request{
+				Serializer:  10,
+				ServiceName: "user-service",
+				MethodName:  "GetById",
+			},
+			wantCode: codes.Unimplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.Invoke(context.Background(), tc.req)
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantData, string(resp.Data))
+		})
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 请求处理的时间
+		sleep   int
+		oneway  bool
+		timeout time.Duration
+		wantErr error
+	}{
+		{
+			name:    "wait for in-flight request",
+			sleep:   20,
+			timeout: time.Second,
+		},
+		{
+			name:    "timeout",
+			sleep:   50,
+			timeout: 100 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "wait for oneway request",
+			sleep:   20,
+			oneway:  true,
+			timeout: time.Second,
+		},
+		{
+			// oneway 的方法不占用连接，也要等它结束
+			name:    "oneway timeout",
+			sleep:   50,
+			oneway:  true,
+			timeout: 100 * time.Millisecond,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer()
+			require.NoError(t, server.RegisterService(&sleepService{}))
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			serveErr := make(chan error, 1)
+			go func() {
+				serveErr <- server.Serve(listener)
+			}()
+
+			client, err := NewClient(listener.Addr().String())
+			require.NoError(t, err)
+			defer client.Close()
+			ss := &SleepService{}
+			require.NoError(t, client.InitService(ss))
+
+			callCtx := context.Background()
+			if tc.oneway {
+				callCtx = CtxWithOneway(callCtx)
+			}
+			callErr := make(chan error, 1)
+			go func() {
+				_, er := ss.GetById(callCtx, &GetByIdReq{Id: tc.sleep})
+				callErr <- er
+			}()
+			// 等请求到达服务端
+			time.Sleep(20 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			err = server.Shutdown(ctx)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, ErrServerClosed, <-serveErr)
+			if err != nil {
+				_ = server.Close()
+				return
+			}
+			// 正在处理的请求能够正常返回
+			if !tc.oneway {
+				assert.NoError(t, <-callErr)
+			}
+			// 不再接收新的连接
+			_, err = NewClient(listener.Addr().String())
+			assert.Error(t, err)
+		})
+	}
+}
+
+// BenchmarkReflectionStub 对比注册时解析方法和每次调用都反射查找方法
+func BenchmarkReflectionStub(b *testing.B) {
+	service := &UserServiceServer{Msg: "hello"}
+	serializers := map[uint8]serialize.Serialize{1: &json.Serializer{}}
+	req := &message.Request{
+		Serializer:  1,
+		ServiceName: "user-service",
+		MethodName:  "GetById",
+		Data:        []byte(`{"Id":123}`),
+	}
+	ctx := context.Background()
+
+	b.Run("cached", func(b *testing.B) {
+		stub, err := newReflectionStub(service, serializers)
+		require.NoError(b, err)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = stub.invoke(ctx, req)
+		}
+	})
+
+	b.Run("per call", func(b *testing.B) {
+		val := reflect.ValueOf(service)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = legacyInvoke(ctx, val, serializers, req)
+		}
+	})
+}
+
+// legacyInvoke 原本的调用方式，每次调用都 MethodByName
+func legacyInvoke(ctx context.Context, value reflect.Value,
+	serializers map[uint8]serialize.Serialize, req *message.Request) ([]byte, error) {
+	method := value.MethodByName(req.MethodName)
+	inReq := reflect.New(method.Type().In(1).Elem())
+	serializer, ok := serializers[req.Serializer]
+	if !ok {
+		return nil, errors.New("micro: 不支持的序列化协议")
+	}
+	err := serializer.Decode(req.Data, inReq.Interface())
+	if err != nil {
+		return nil, err
+	}
+	results := method.Call([]reflect.Value{reflect.ValueOf(ctx), inReq})
+	if results[1].Interface() != nil {
+		return nil, results[1].Interface().(error)
+	}
+	return serializer.Encode(results[0].Interface())
+}
+
+type noCtxService struct{}
+
+func (n *noCtxService) GetById(req *GetByIdReq) (*GetByIdResp, error) {
+	return &GetByIdResp{}, nil
+}
+
+func (n *noCtxService) Name() string {
+	return "no-ctx"
+}
+
+type noErrService struct{}
+
+func (n *noErrService) GetById(ctx context.Context, req *GetByIdReq) *GetByIdResp {
+	return &GetByIdResp{}
+}
+
+func (n *noErrService) Name() string {
+	return "no-err"
+}
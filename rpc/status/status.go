@@ -0,0 +1,126 @@
+package status
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"web/micro/rpc/codes"
+)
+
+// Status 带错误码的错误，服务端返回之后会编码进 message.Response 的 Error 字段里
+// 客户端收到之后再解码回来，所以可以用 errors.As 或者 Code 来判断错误的类型
+type Status struct {
+	Code    codes.Code
+	Message string
+	// 附加的错误详情，用什么格式序列化由用户自己决定
+	Details []byte
+}
+
+func New(c codes.Code, msg string) *Status {
+	return &Status{Code: c, Message: msg}
+}
+
+// Error 创建一个带错误码的 error
+func Error(c codes.Code, msg string) error {
+	return New(c, msg)
+}
+
+func Errorf(c codes.Code, format string, args ...any) error {
+	return New(c, fmt.Sprintf(format, args...))
+}
+
+// WithDetails 返回一个带有详情的副本
+func (s *Status) WithDetails(details []byte) *Status {
+	res := *s
+	res.Details = details
+	return &res
+}
+
+// Error 只返回错误信息，和原本直接传输错误字符串的行为保持一致
+func (s *Status) Error() string {
+	return s.Message
+}
+
+// Is 错误码和错误信息一样就认为是同一个错误
+func (s *Status) Is(target error) bool {
+	t, ok := target.(*Status)
+	if !ok {
+		return false
+	}
+	return s.Code == t.Code && s.Message == t.Message
+}
+
+// FromError 从 err 中找出 *Status，找不到的时候返回 false
+func FromError(err error) (*Status, bool) {
+	var s *Status
+	if errors.As(err, &s) {
+		return s, true
+	}
+	return nil, false
+}
+
+// Convert 把任意的 error 转成 *Status
+// err 为 nil 的时候返回 nil，不认识的错误当作 Unknown
+func Convert(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	if s, ok := FromError(err); ok {
+		return s
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return New(codes.Canceled, err.Error())
+	case errors.As(err, &netErr):
+		// 网络错误，比如连不上服务端
+		return New(codes.Unavailable, err.Error())
+	default:
+		return New(codes.Unknown, err.Error())
+	}
+}
+
+// Code 返回 err 的错误码，nil 对应 OK
+func Code(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	return Convert(err).Code
+}
+
+// Encode 编码成 message.Response 的 Error 字段
+// 1 字节错误码 + 4 字节错误信息长度 + 错误信息 + 详情
+func Encode(s *Status) []byte {
+	bs := make([]byte, 5+len(s.Message)+len(s.Details))
+	bs[0] = byte(s.Code)
+	binary.BigEndian.PutUint32(bs[1:5], uint32(len(s.Message)))
+	cur := bs[5:]
+	copy(cur, s.Message)
+	cur = cur[len(s.Message):]
+	copy(cur, s.Details)
+	return bs
+}
+
+// Decode 解码 message.Response 的 Error 字段
+// 格式不对的时候，整个当作错误信息，错误码是 Unknown
+func Decode(data []byte) *Status {
+	if len(data) < 5 {
+		return New(codes.Unknown, string(data))
+	}
+	msgLen := binary.BigEndian.Uint32(data[1:5])
+	if uint64(msgLen) > uint64(len(data)-5) {
+		return New(codes.Unknown, string(data))
+	}
+	res := &Status{
+		Code:    codes.Code(data[0]),
+		Message: string(data[5 : 5+msgLen]),
+	}
+	if details := data[5+msgLen:]; len(details) > 0 {
+		res.Details = details
+	}
+	return res
+}
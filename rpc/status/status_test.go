@@ -0,0 +1,83 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"web/micro/rpc/codes"
+)
+
+func TestEnDecode(t *testing.T) {
+	testCases := []struct {
+		name string
+		s    *Status
+	}{
+		{
+			name: "normal",
+			s:    New(codes.NotFound, "rpc: 要调用的服务不存在"),
+		},
+		{
+			name: "with details",
+			s:    New(codes.InvalidArgument, "id 不能为负数").WithDetails([]byte(`{"field":"id"}`)),
+		},
+		{
+			name: "empty message",
+			s:    New(codes.Internal, ""),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 对称过程，可以这样进行测试
+			s := Decode(Encode(tc.s))
+			assert.Equal(t, tc.s, s)
+		})
+	}
+}
+
+func TestDecodeRawError(t *testing.T) {
+	// 旧的服务端直接把错误字符串写进去
+	s := Decode([]byte("Error"))
+	assert.Equal(t, New(codes.Unknown, "Error"), s)
+}
+
+func TestCode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+	}{
+		{
+			name:     "nil",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "status",
+			err:      Error(codes.NotFound, "not found"),
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "wrapped",
+			err:      fmt.Errorf("wrap: %w", Error(codes.PermissionDenied, "denied")),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "deadline",
+			err:      context.DeadlineExceeded,
+			wantCode: codes.DeadlineExceeded,
+		},
+		{
+			name:     "unknown",
+			err:      errors.New("mock error"),
+			wantCode: codes.Unknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantCode, Code(tc.err))
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/serialize"
+	"web/micro/rpc/status"
+)
+
+var (
+	errStreamReset = status.Error(codes.Canceled, "micro: 流被对端中断")
+	errSendClosed  = errors.New("micro: 流已经关闭发送")
+	// errFlowControl 对端不遵守窗口，发送了过多的消息
+	errFlowControl = status.Error(codes.ResourceExhausted, "micro: 流的接收队列已满")
+)
+
+// streamWindow 每个流在每个方向上最多有多少个还没有被 Recv 的消息
+// 发送方用完了窗口就等待，接收方 Recv 了一半窗口之后告诉发送方可以继续发送
+const streamWindow = 64
+
+// Stream 流式调用，一次调用里面可以多次发送和接收消息
+// 服务端的方法写成 func(ctx context.Context, stream Stream) error 就是流式方法，
+// 客户端的字段写成 func(ctx context.Context) (Stream, error) 就可以打开一个流
+type Stream interface {
+	Context() context.Context
+	// Send 发送一个消息
+	Send(msg any) error
+	// Recv 接收一个消息，msg 应该是一个结构体指针
+	// 对端正常结束之后返回 io.EOF
+	Recv(msg any) error
+	// CloseSend 告诉对端不会再发送消息了，仍然可以继续接收
+	CloseSend() error
+}
+
+// frameQueue 接收消息的队列
+// 读协程往里面放，不能因为某个流没有及时 Recv 就把整个连接都卡住，所以放不下的时候不等待
+// 发送方遵守窗口的话队列不会超过 streamWindow，超过了说明对端有问题
+type frameQueue[T any] struct {
+	mutex  sync.Mutex
+	items  []T
+	limit  int
+	err    error
+	notify chan struct{}
+}
+
+func newFrameQueue[T any]() *frameQueue[T] {
+	return &frameQueue[T]{limit: streamWindow, notify: make(chan struct{}, 1)}
+}
+
+// push 队列满了或者已经关闭的时候返回 false
+func (q *frameQueue[T]) push(item T) bool {
+	q.mutex.Lock()
+	if q.err != nil || len(q.items) >= q.limit {
+		q.mutex.Unlock()
+		return false
+	}
+	q.items = append(q.items, item)
+	q.mutex.Unlock()
+	q.signal()
+	return true
+}
+
+// close 之后，队列里剩下的元素取完了就返回 err
+func (q *frameQueue[T]) close(err error) {
+	q.mutex.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mutex.Unlock()
+	q.signal()
+}
+
+func (q *frameQueue[T]) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *frameQueue[T]) pop(ctx context.Context) (T, error) {
+	for {
+		q.mutex.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items = q.items[1:]
+			q.mutex.Unlock()
+			return item, nil
+		}
+		err := q.err
+		q.mutex.Unlock()
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// sendWindow 发送方的窗口，还可以发送多少个消息
+type sendWindow struct {
+	mutex   sync.Mutex
+	credits int
+	notify  chan struct{}
+}
+
+func newSendWindow() *sendWindow {
+	return &sendWindow{credits: streamWindow, notify: make(chan struct{}, 1)}
+}
+
+// acquire 用掉一个窗口，没有窗口的时候等到对端的窗口更新，或者 done 被关闭
+func (w *sendWindow) acquire(ctx context.Context, done <-chan struct{}) error {
+	for {
+		w.mutex.Lock()
+		if w.credits > 0 {
+			w.credits--
+			w.mutex.Unlock()
+			return nil
+		}
+		w.mutex.Unlock()
+		select {
+		case <-w.notify:
+		case <-done:
+			return errSendClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *sendWindow) release(n int) {
+	w.mutex.Lock()
+	w.credits += n
+	w.mutex.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// recvWindow 接收方记录 Recv 了多少个消息，攒够半个窗口就通知发送方
+type recvWindow struct {
+	mutex    sync.Mutex
+	consumed int
+}
+
+// consume 返回需要通知发送方的数量，0 表示还不需要
+func (w *recvWindow) consume() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.consumed++
+	if w.consumed < streamWindow/2 {
+		return 0
+	}
+	n := w.consumed
+	w.consumed = 0
+	return n
+}
+
+func encodeWindow(n int) []byte {
+	bs := make([]byte, 4)
+	binary.BigEndian.PutUint32(bs, uint32(n))
+	return bs
+}
+
+func decodeWindow(data []byte) int {
+	if len(data) < 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(data))
+}
+
+// clientStream 客户端的流，RequestId 就是流的 id
+type clientStream struct {
+	ctx         context.Context
+	cc          *clientConn
+	id          uint32
+	serializer  serialize.Serialize
+	frames      *frameQueue[*message.Response]
+	sendWindow  *sendWindow
+	recvWindow  recvWindow
+	sendMutex   sync.Mutex
+	sendClosed  bool
+	finished    bool
+	finishMutex sync.Mutex
+	// 流结束的时候关闭
+	done chan struct{}
+}
+
+func newClientStream(ctx context.Context, cc *clientConn, id uint32, s serialize.Serialize) *clientStream {
+	return &clientStream{
+		ctx:        ctx,
+		cc:         cc,
+		id:         id,
+		serializer: s,
+		frames:     newFrameQueue[*message.Response](),
+		sendWindow: newSendWindow(),
+		done:       make(chan struct{}),
+	}
+}
+
+// watch ctx 被取消的时候通知服务端中断流
+func (s *clientStream) watch() {
+	select {
+	case <-s.ctx.Done():
+		s.reset()
+	case <-s.done:
+	}
+}
+
+func (s *clientStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *clientStream) Send(msg any) error {
+	data, err := s.serializer.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if err = s.sendWindow.acquire(s.ctx, s.done); err != nil {
+		return err
+	}
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.sendClosed {
+		return errSendClosed
+	}
+	return s.cc.write(s.frame(message.FlagStream|message.FlagData, data))
+}
+
+func (s *clientStream) CloseSend() error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	return s.cc.write(s.frame(message.FlagStream|message.FlagHalfClose, nil))
+}
+
+func (s *clientStream) Recv(msg any) error {
+	resp, err := s.frames.pop(s.ctx)
+	if err != nil {
+		return err
+	}
+	if n := s.recvWindow.consume(); n > 0 {
+		_ = s.cc.write(s.frame(message.FlagStream|message.FlagWindow, encodeWindow(n)))
+	}
+	return s.serializer.Decode(resp.Data, msg)
+}
+
+// onFrame 读协程收到服务端发来的帧
+// 服务端结束了流之后马上释放，不需要等调用方 Recv 到最后
+func (s *clientStream) onFrame(resp *message.Response) {
+	switch {
+	case resp.Flag&message.FlagReset != 0:
+		s.finish(errStreamReset)
+	case resp.Flag&message.FlagHalfClose != 0:
+		// 服务端的方法已经返回了，带回来的错误就是结果
+		var err error = io.EOF
+		if len(resp.Error) > 0 {
+			err = status.Decode(resp.Error)
+		}
+		s.finish(err)
+	case resp.Flag&message.FlagWindow != 0:
+		s.sendWindow.release(decodeWindow(resp.Data))
+	case resp.Flag&message.FlagData != 0:
+		if !s.frames.push(resp) {
+			s.abort(errFlowControl)
+		}
+	}
+}
+
+func (s *clientStream) frame(flag uint8, data []byte) *message.Request {
+	req := &message.Request{
+		RequestId:  s.id,
+		Serializer: s.serializer.Code(),
+		Flag:       flag,
+		Data:       data,
+	}
+	req.CalculateHeadLength()
+	req.CalculateBodyLength()
+	return req
+}
+
+func (s *clientStream) reset() {
+	// ctx 被取消了，之后的 Recv 返回 ctx 的错误
+	s.abort(s.ctx.Err())
+}
+
+// abort 中断流，并且通知服务端
+func (s *clientStream) abort(err error) {
+	s.sendMutex.Lock()
+	s.sendClosed = true
+	s.sendMutex.Unlock()
+	if s.finish(err) {
+		_ = s.cc.write(s.frame(message.FlagStream|message.FlagReset, nil))
+	}
+}
+
+// finish 流结束了，之后的 Recv 都返回 err，第一次调用的时候返回 true
+func (s *clientStream) finish(err error) bool {
+	s.finishMutex.Lock()
+	defer s.finishMutex.Unlock()
+	if s.finished {
+		return false
+	}
+	s.finished = true
+	s.frames.close(err)
+	close(s.done)
+	s.cc.removeStream(s.id)
+	return true
+}
+
+// serverStream 服务端的流，交给流式方法使用
+type serverStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	conn   *serverConn
+	id     uint32
+	// 客户端使用的序列化协议，服务端不支持的时候 serializer 是 nil
+	code       uint8
+	serializer serialize.Serialize
+	frames     *frameQueue[*message.Request]
+	sendWindow *sendWindow
+	recvWindow recvWindow
+	mutex      sync.Mutex
+	sendClosed bool
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) Send(msg any) error {
+	data, err := s.serializer.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if err = s.sendWindow.acquire(s.ctx, nil); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sendClosed {
+		return errSendClosed
+	}
+	if err = s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.conn.write(s.frame(message.FlagStream|message.FlagData, data, nil))
+}
+
+// CloseSend 服务端的流在方法返回的时候才真正结束，这里只是不允许再发送
+func (s *serverStream) CloseSend() error {
+	s.mutex.Lock()
+	s.sendClosed = true
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *serverStream) Recv(msg any) error {
+	req, err := s.frames.pop(s.ctx)
+	if err != nil {
+		return err
+	}
+	if n := s.recvWindow.consume(); n > 0 {
+		_ = s.conn.write(s.frame(message.FlagStream|message.FlagWindow, encodeWindow(n), nil))
+	}
+	return s.serializer.Decode(req.Data, msg)
+}
+
+// finish 方法返回之后，把结果发送给客户端
+func (s *serverStream) finish(err error) {
+	s.mutex.Lock()
+	s.sendClosed = true
+	s.mutex.Unlock()
+	var errBs []byte
+	if err != nil {
+		errBs = status.Encode(status.Convert(err))
+	}
+	// 客户端已经中断了，就不需要再发送了，超时的时候还是要告诉客户端
+	if !errors.Is(s.ctx.Err(), context.Canceled) {
+		_ = s.conn.write(s.frame(message.FlagStream|message.FlagHalfClose, nil, errBs))
+	}
+	s.cancel()
+}
+
+func (s *serverStream) frame(flag uint8, data []byte, errBs []byte) *message.Response {
+	resp := &message.Response{
+		RequestId:  s.id,
+		Serializer: s.code,
+		Flag:       flag,
+		Error:      errBs,
+		Data:       data,
+	}
+	resp.CalculateHeadLength()
+	resp.CalculateBodyLength()
+	return resp
+}
+
+// onFrame 收到客户端发来的帧
+func (s *serverStream) onFrame(req *message.Request) {
+	if req.Flag&message.FlagReset != 0 {
+		s.frames.close(errStreamReset)
+		s.cancel()
+		return
+	}
+	if req.Flag&message.FlagWindow != 0 {
+		s.sendWindow.release(decodeWindow(req.Data))
+	}
+	// 队列满了说明客户端不遵守窗口，Recv 返回错误，方法返回之后错误会发给客户端
+	if req.Flag&message.FlagData != 0 && !s.frames.push(req) {
+		s.frames.close(errFlowControl)
+	}
+	if req.Flag&message.FlagHalfClose != 0 {
+		s.frames.close(io.EOF)
+	}
+}
@@ -0,0 +1,243 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/status"
+)
+
+func TestStream(t *testing.T) {
+	server := NewServer()
+	require.NoError(t, server.RegisterService(&streamService{}))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	svc := &StreamService{}
+	require.NoError(t, client.InitService(svc))
+
+	t.Run("server streaming", func(t *testing.T) {
+		st, err := svc.Count(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, st.Send(&GetByIdReq{Id: 3}))
+		require.NoError(t, st.CloseSend())
+		var ids []int
+		for {
+			resp := &GetByIdReq{}
+			err = st.Recv(resp)
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			ids = append(ids, resp.Id)
+		}
+		assert.Equal(t, []int{0, 1, 2}, ids)
+	})
+
+	t.Run("client streaming", func(t *testing.T) {
+		st, err := svc.Sum(context.Background())
+		require.NoError(t, err)
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, st.Send(&GetByIdReq{Id: i}))
+		}
+		require.NoError(t, st.CloseSend())
+		resp := &GetByIdReq{}
+		require.NoError(t, st.Recv(resp))
+		assert.Equal(t, 10, resp.Id)
+		assert.Equal(t, io.EOF, st.Recv(resp))
+	})
+
+	t.Run("bidirectional", func(t *testing.T) {
+		st, err := svc.Echo(context.Background())
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, st.Send(&GetByIdReq{Id: i}))
+			resp := &GetByIdReq{}
+			require.NoError(t, st.Recv(resp))
+			assert.Equal(t, i, resp.Id)
+		}
+		require.NoError(t, st.CloseSend())
+		assert.Equal(t, io.EOF, st.Recv(&GetByIdReq{}))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		st, err := svc.Fail(context.Background())
+		require.NoError(t, err)
+		err = st.Recv(&GetByIdReq{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("method not found", func(t *testing.T) {
+		st, err := client.NewStream(context.Background(), "stream-service", "Unknown")
+		require.NoError(t, err)
+		err = st.Recv(&GetByIdReq{})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		st, err := svc.Deadline(ctx)
+		require.NoError(t, err)
+		resp := &GetByIdReq{}
+		require.NoError(t, st.Recv(resp))
+		// 服务端拿到的是剩下的时间
+		assert.Greater(t, resp.Id, 0)
+		assert.LessOrEqual(t, resp.Id, 1000)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		st, err := svc.Echo(ctx)
+		require.NoError(t, err)
+		cancel()
+		err = st.Recv(&GetByIdReq{})
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+type StreamService struct {
+	Count func(ctx context.Context) (Stream, error)
+	Sum   func(ctx context.Context) (Stream, error)
+	Echo  func(ctx context.Context) (Stream, error)
+	Fail  func(ctx context.Context) (Stream, error)
+	// Deadline 返回服务端的 ctx 剩下的毫秒数
+	Deadline func(ctx context.Context) (Stream, error)
+}
+
+func (s *StreamService) Name() string {
+	return "stream-service"
+}
+
+type streamService struct{}
+
+func (s *streamService) Name() string {
+	return "stream-service"
+}
+
+// Count 收到 n 之后，返回 0 到 n-1
+func (s *streamService) Count(ctx context.Context, st Stream) error {
+	req := &GetByIdReq{}
+	if err := st.Recv(req); err != nil {
+		return err
+	}
+	for i := 0; i < req.Id; i++ {
+		if err := st.Send(&GetByIdReq{Id: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamService) Sum(ctx context.Context, st Stream) error {
+	sum := 0
+	for {
+		req := &GetByIdReq{}
+		err := st.Recv(req)
+		if errors.Is(err, io.EOF) {
+			return st.Send(&GetByIdReq{Id: sum})
+		}
+		if err != nil {
+			return err
+		}
+		sum += req.Id
+	}
+}
+
+func (s *streamService) Echo(ctx context.Context, st Stream) error {
+	for {
+		req := &GetByIdReq{}
+		err := st.Recv(req)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = st.Send(req); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *streamService) Fail(ctx context.Context, st Stream) error {
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case <-ctx.Done():
+	}
+	return status.Error(codes.InvalidArgument, "参数错误")
+}
+
+func (s *streamService) Deadline(ctx context.Context, st Stream) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return st.Send(&GetByIdReq{})
+	}
+	return st.Send(&GetByIdReq{Id: int(time.Until(deadline).Milliseconds())})
+}
+
+// TestStream_FlowControl 接收方不 Recv 的时候发送方停下来，不影响同一个连接上的其它调用
+func TestStream_FlowControl(t *testing.T) {
+	server := NewServer()
+	require.NoError(t, server.RegisterService(&streamService{}))
+	require.NoError(t, server.RegisterService(&UserServiceServer{Msg: "hello"}))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	svc := &StreamService{}
+	require.NoError(t, client.InitService(svc))
+	us := &userServiceProxy{}
+	require.NoError(t, client.InitService(us))
+
+	const n = streamWindow * 10
+	st, err := svc.Count(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, st.Send(&GetByIdReq{Id: n}))
+	time.Sleep(50 * time.Millisecond)
+	cs := st.(*clientStream)
+	cs.frames.mutex.Lock()
+	queued := len(cs.frames.items)
+	cs.frames.mutex.Unlock()
+	assert.LessOrEqual(t, queued, streamWindow)
+
+	resp, err := us.GetById(context.Background(), &GetByIdReq{Id: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", resp.Msg)
+
+	for i := 0; i < n; i++ {
+		msg := &GetByIdReq{}
+		require.NoError(t, st.Recv(msg))
+		assert.Equal(t, i, msg.Id)
+	}
+	assert.Equal(t, io.EOF, st.Recv(&GetByIdReq{}))
+}
+
+// TestStream_Release 服务端结束了流，调用方没有 Recv 到最后也会释放
+func TestStream_Release(t *testing.T) {
+	server := NewServer()
+	require.NoError(t, server.RegisterService(&streamService{}))
+	client, err := NewClient(startTestServer(t, server))
+	require.NoError(t, err)
+	defer client.Close()
+	svc := &StreamService{}
+	require.NoError(t, client.InitService(svc))
+
+	st, err := svc.Fail(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, st.CloseSend())
+	cs := st.(*clientStream)
+	select {
+	case <-cs.done:
+	case <-time.After(time.Second):
+		t.Fatal("流没有释放")
+	}
+	cs.cc.mutex.Lock()
+	assert.Empty(t, cs.cc.streams)
+	cs.cc.mutex.Unlock()
+	assert.Equal(t, codes.InvalidArgument, status.Code(st.Recv(&GetByIdReq{})))
+}
@@ -0,0 +1,189 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"web/micro/rpc/codes"
+	"web/micro/rpc/message"
+	"web/micro/rpc/serialize"
+	"web/micro/rpc/status"
+)
+
+var errUnsupportedSerializer = status.Error(codes.Unimplemented, "micro: 不支持的序列化协议")
+
+// stub 服务端调用服务的方式
+type stub interface {
+	invoke(ctx context.Context, req *message.Request) ([]byte, error)
+}
+
+// streamStub 支持流式方法的 stub
+type streamStub interface {
+	invokeStream(ctx context.Context, methodName string, st Stream) error
+}
+
+// Dispatcher 自己根据方法名分发请求的服务，不需要反射，一般由 micro-gen 生成
+type Dispatcher interface {
+	Service
+	// Dispatch 调用 methodName 对应的方法，data 和返回值都是用 s 编码的
+	Dispatch(ctx context.Context, methodName string, s serialize.Serialize, data []byte) ([]byte, error)
+}
+
+// ErrMethodNotFound 要调用的方法不存在
+func ErrMethodNotFound(serviceName, methodName string) error {
+	return status.Errorf(codes.NotFound, "rpc: 要调用的方法不存在 %s.%s", serviceName, methodName)
+}
+
+// DecodeRequest 反序列化请求，失败的时候返回 InvalidArgument
+func DecodeRequest(s serialize.Serialize, data []byte, req any) error {
+	if err := s.Decode(data, req); err != nil {
+		return status.Errorf(codes.InvalidArgument, "micro: 反序列化请求失败 %v", err)
+	}
+	return nil
+}
+
+// EncodeResponse 序列化响应，resp 不能是 nil
+// 业务返回了数据也返回了错误，两个都要带回去
+func EncodeResponse(s serialize.Serialize, resp any, err error) ([]byte, error) {
+	res, er := s.Encode(resp)
+	if er != nil {
+		return nil, status.Errorf(codes.Internal, "micro: 序列化响应失败 %v", er)
+	}
+	return res, err
+}
+
+type dispatcherStub struct {
+	d           Dispatcher
+	serializers map[uint8]serialize.Serialize
+}
+
+func (s *dispatcherStub) invoke(ctx context.Context, req *message.Request) ([]byte, error) {
+	serializer, ok := s.serializers[req.Serializer]
+	if !ok {
+		return nil, errUnsupportedSerializer
+	}
+	return s.d.Dispatch(ctx, req.MethodName, serializer, req.Data)
+}
+
+var (
+	ctxType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errType    = reflect.TypeOf((*error)(nil)).Elem()
+	streamType = reflect.TypeOf((*Stream)(nil)).Elem()
+)
+
+// reflectionStub 的出现是为了防止，如果以后可能会需要使用到unsafe
+type reflectionStub struct {
+	s Service
+	// 我们也不知道reflection要用哪个，所以全部传下来
+	//serializer serialize.Serialize
+	serializers map[uint8]serialize.Serialize
+	value       reflect.Value
+	// 注册的时候解析好的方法，key 是方法名
+	methods map[string]*methodDesc
+	// 流式方法，签名是 func(context.Context, Stream) error
+	streams map[string]reflect.Value
+}
+
+// methodDesc 缓存方法的反射信息，避免每次调用都 MethodByName
+type methodDesc struct {
+	// 已经绑定了接收者的方法
+	fn reflect.Value
+	// 请求参数的类型，是指针指向的结构体类型
+	reqType reflect.Type
+}
+
+func newReflectionStub(service Service, serializers map[uint8]serialize.Serialize) (*reflectionStub, error) {
+	if service == nil {
+		return nil, errors.New("rpc: 不支持 nil")
+	}
+	val := reflect.ValueOf(service)
+	typ := val.Type()
+	res := &reflectionStub{
+		s:           service,
+		serializers: serializers,
+		value:       val,
+		methods:     make(map[string]*methodDesc, typ.NumMethod()),
+		streams:     make(map[string]reflect.Value, 2),
+	}
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		// Name 是 Service 接口要求的方法，不是 RPC 方法
+		if method.Name == "Name" {
+			continue
+		}
+		// 这里的 Type 不包含接收者
+		fn := val.Method(i)
+		if isStreamMethod(fn.Type()) {
+			res.streams[method.Name] = fn
+			continue
+		}
+		if err := checkMethod(fn.Type()); err != nil {
+			return nil, fmt.Errorf("rpc: 服务 %s 的方法 %s 不合法: %w", service.Name(), method.Name, err)
+		}
+		res.methods[method.Name] = &methodDesc{
+			fn:      fn,
+			reqType: fn.Type().In(1).Elem(),
+		}
+	}
+	return res, nil
+}
+
+func isStreamMethod(typ reflect.Type) bool {
+	return typ.NumIn() == 2 && typ.In(0) == ctxType && typ.In(1) == streamType &&
+		typ.NumOut() == 1 && typ.Out(0) == errType
+}
+
+// checkMethod 检查方法签名是不是 func(context.Context, *Req) (*Resp, error)
+func checkMethod(typ reflect.Type) error {
+	if typ.NumIn() != 2 || typ.In(0) != ctxType {
+		return errors.New("第一个参数必须是 context.Context，并且只能有两个参数")
+	}
+	if typ.In(1).Kind() != reflect.Pointer || typ.In(1).Elem().Kind() != reflect.Struct {
+		return errors.New("第二个参数必须是指向结构体的指针")
+	}
+	if typ.NumOut() != 2 || typ.Out(1) != errType {
+		return errors.New("必须返回两个值，并且第二个是 error")
+	}
+	if typ.Out(0).Kind() != reflect.Pointer || typ.Out(0).Elem().Kind() != reflect.Struct {
+		return errors.New("第一个返回值必须是指向结构体的指针")
+	}
+	return nil
+}
+
+func (s *reflectionStub) invoke(ctx context.Context, req *message.Request) ([]byte, error) {
+	method, ok := s.methods[req.MethodName]
+	if !ok {
+		return nil, ErrMethodNotFound(req.ServiceName, req.MethodName)
+	}
+	serializer, ok := s.serializers[req.Serializer]
+	if !ok {
+		return nil, errUnsupportedSerializer
+	}
+	inReq := reflect.New(method.reqType)
+	err := DecodeRequest(serializer, req.Data, inReq.Interface())
+	if err != nil {
+		return nil, err
+	}
+	results := method.fn.Call([]reflect.Value{reflect.ValueOf(ctx), inReq})
+
+	if results[1].Interface() != nil {
+		err = results[1].Interface().(error)
+	}
+	if results[0].IsNil() {
+		return nil, err
+	}
+	return EncodeResponse(serializer, results[0].Interface(), err)
+}
+
+func (s *reflectionStub) invokeStream(ctx context.Context, methodName string, st Stream) error {
+	fn, ok := s.streams[methodName]
+	if !ok {
+		return ErrMethodNotFound(s.s.Name(), methodName)
+	}
+	results := fn.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(st)})
+	if err, ok := results[0].Interface().(error); ok {
+		return err
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"encoding/binary"
+	"io"
 	"net"
 )
 
@@ -9,7 +10,8 @@ import (
 func ReadMsg(conn net.Conn) ([]byte, error) {
 	lenBs := make([]byte, numOfLengthBytes)
 	// 先读8字节，读取长度，获取字节大小
-	_, err := conn.Read(lenBs)
+	// 一个连接上会连续发送多个消息，所以必须读满，不能用 conn.Read
+	_, err := io.ReadFull(conn, lenBs)
 	if err != nil {
 		return nil, err
 	}
@@ -24,7 +26,7 @@ func ReadMsg(conn net.Conn) ([]byte, error) {
 	// 读取响应的数据大小
 	data := make([]byte, length)
 	copy(data[:8], lenBs)
-	_, err = conn.Read(data[8:])
+	_, err = io.ReadFull(conn, data[8:])
 	return data, err
 }
 
@@ -13,3 +13,8 @@ type Service interface {
 type Proxy interface {
 	Invoke(ctx context.Context, req *message.Request) (*message.Response, error)
 }
+
+// StreamProxy 支持流式调用的 Proxy，Client 实现了这个接口
+type StreamProxy interface {
+	NewStream(ctx context.Context, serviceName, methodName string) (Stream, error)
+}
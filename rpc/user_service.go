@@ -4,6 +4,8 @@ import (
 	"context"
 )
 
+//go:generate go run ../cmd/micro-gen -type UserService
+
 type UserService interface {
 	GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error)
 	Name() string
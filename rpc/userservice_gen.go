@@ -0,0 +1,58 @@
+// Code generated by micro-gen. DO NOT EDIT.
+
+package rpc
+
+import (
+	"context"
+	"web/micro/rpc/serialize"
+)
+
+// UserServiceClient 不使用反射的 UserService 客户端
+type UserServiceClient struct {
+	p Proxy
+	s serialize.Serialize
+}
+
+func NewUserServiceClient(p Proxy, s serialize.Serialize) *UserServiceClient {
+	return &UserServiceClient{p: p, s: s}
+}
+
+func (c *UserServiceClient) Name() string {
+	return "user-service"
+}
+
+func (c *UserServiceClient) GetById(ctx context.Context, req *GetByIdReq) (*GetByIdResp, error) {
+	resp := new(GetByIdResp)
+	err := Call(ctx, c.p, c.s, c.Name(), "GetById", req, resp)
+	return resp, err
+}
+
+// UserServiceDispatcher 不使用反射的服务端，实现了 Dispatcher，通过 RegisterService 注册
+type UserServiceDispatcher struct {
+	impl UserService
+}
+
+func NewUserServiceDispatcher(impl UserService) *UserServiceDispatcher {
+	return &UserServiceDispatcher{impl: impl}
+}
+
+func (d *UserServiceDispatcher) Name() string {
+	return "user-service"
+}
+
+func (d *UserServiceDispatcher) Dispatch(ctx context.Context, methodName string, s serialize.Serialize, data []byte) ([]byte, error) {
+	switch methodName {
+	case "GetById":
+		req := new(GetByIdReq)
+		if err := DecodeRequest(s, data, req); err != nil {
+			return nil, err
+		}
+		resp, err := d.impl.GetById(ctx, req)
+		if resp == nil {
+			return nil, err
+		}
+		return EncodeResponse(s, resp, err)
+	default:
+		return nil, ErrMethodNotFound(d.Name(), methodName)
+	}
+}
@@ -1,20 +1,30 @@
 package micro
 
 import (
-	"context"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"net"
 	"time"
+	"web/micro/health"
 	"web/micro/registry"
 )
 
 type Server struct {
-	name            string
-	registry        registry.Registry
+	name     string
+	registry registry.Registry
+	// 注册到注册中心的实例信息，Name 和 Address 在 Start 的时候填充
+	instance        registry.ServiceInstance
 	registerTimeout time.Duration
+	// 为 0 的时候只注册一次
+	registerRefresh time.Duration
+	grpcOpts        []grpc.ServerOption
 	*grpc.Server
 	// 在close方法里，我们会关闭，所以要在这里维持
 	listener net.Listener
+	health   *health.Server
+	// 注册中心里面的实例跟着健康状态上下线
+	registrar *health.Registrar
 }
 
 type ServerOption func(*Server)
@@ -22,28 +32,104 @@ type ServerOption func(*Server)
 func NewServer(name string, opts ...ServerOption) (*Server, error) {
 	res := &Server{
 		name:            name,
-		Server:          grpc.NewServer(),
 		registerTimeout: 10 * time.Second,
+		health:          health.NewServer(),
 	}
 
 	for _, opt := range opts {
 		opt(res)
 	}
+	res.Server = grpc.NewServer(res.grpcOpts...)
+	// 标准的 grpc.health.v1 服务，状态和 health.Server 保持一致
+	grpcHealth := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(res.Server, grpcHealth)
+	res.health.OnChange(func(service string, status health.Status) {
+		grpcHealth.SetServingStatus(service, toGrpcStatus(status))
+	})
 	return res, nil
 }
 
+// Health 通过它设置健康状态
+// 空的服务名表示整个进程，变成 NOT_SERVING 的时候会从注册中心注销，恢复之后重新注册
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+func toGrpcStatus(status health.Status) healthpb.HealthCheckResponse_ServingStatus {
+	switch status {
+	case health.StatusServing:
+		return healthpb.HealthCheckResponse_SERVING
+	case health.StatusNotServing:
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	default:
+		return healthpb.HealthCheckResponse_UNKNOWN
+	}
+}
+
 func ServerWithRegistry(reg registry.Registry) ServerOption {
 	return func(s *Server) {
 		s.registry = reg
 	}
 }
 
+// ServerWithWeight 设置实例的权重
+func ServerWithWeight(weight uint32) ServerOption {
+	return func(s *Server) {
+		s.instance.Weight = weight
+	}
+}
+
+func ServerWithVersion(version string) ServerOption {
+	return func(s *Server) {
+		s.instance.Version = version
+	}
+}
+
+func ServerWithGroup(group string) ServerOption {
+	return func(s *Server) {
+		s.instance.Group = group
+	}
+}
+
+func ServerWithTags(tags ...string) ServerOption {
+	return func(s *Server) {
+		s.instance.Tags = append(s.instance.Tags, tags...)
+	}
+}
+
+// ServerWithMetadata 设置实例的元数据，可以多次调用
+func ServerWithMetadata(md map[string]string) ServerOption {
+	return func(s *Server) {
+		if s.instance.Metadata == nil {
+			s.instance.Metadata = make(map[string]string, len(md))
+		}
+		for k, v := range md {
+			s.instance.Metadata[k] = v
+		}
+	}
+}
+
+// ServerWithGrpcOptions 创建 grpc.Server 的时候使用的选项，比如拦截器
+func ServerWithGrpcOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(s *Server) {
+		s.grpcOpts = append(s.grpcOpts, opts...)
+	}
+}
+
 func ServerWithRegisterTimeout(timeout time.Duration) ServerOption {
 	return func(s *Server) {
 		s.registerTimeout = timeout
 	}
 }
 
+// ServerWithRegisterRefresh 注册之后每隔 interval 重新注册一次
+// 注册中心需要注册方续约的时候使用，比如设置了 TTL 的 memory.Registry
+func ServerWithRegisterRefresh(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.registerRefresh = interval
+	}
+}
+
 // Start 当用户调用Start的时候，就意味着服务已经准备完成，开始注册
 func (s *Server) Start(addr string) error {
 	listener, err := net.Listen("tcp", addr)
@@ -53,14 +139,15 @@ func (s *Server) Start(addr string) error {
 	s.listener = listener
 
 	if s.registry != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), s.registerTimeout)
-		defer cancel()
-		r := registry.ServiceInstance{
-			Name:    s.name,
-			Address: listener.Addr().String(),
-		}
-		err = s.registry.Register(ctx, r)
-		if err != nil {
+		r := s.instance
+		r.Name = s.name
+		r.Address = listener.Addr().String()
+		s.registrar = health.NewRegistrar(s.registry, r, s.registerTimeout,
+			health.RegistrarWithRefresh(s.registerRefresh))
+		// 健康的时候马上注册，之后跟着健康状态上下线
+		s.health.OnChange(s.registrar.OnChange)
+		if err = s.registrar.Err(); err != nil {
+			_ = listener.Close()
 			return err
 		}
 
@@ -74,6 +161,8 @@ func (s *Server) Start(addr string) error {
 }
 
 func (s *Server) Close() error {
+	// 先从注册中心注销，健康检查也返回 NOT_SERVING
+	s.health.Shutdown()
 	if s.registry != nil {
 		err := s.registry.Close()
 		if err != nil {
@@ -0,0 +1,87 @@
+package micro
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"testing"
+	"time"
+	"web/micro/health"
+	"web/micro/registry/memory"
+)
+
+// TestServer_Health 健康状态变化的时候，注册中心和 grpc.health.v1 都跟着变化
+func TestServer_Health(t *testing.T) {
+	r := memory.NewRegistry()
+	server, err := NewServer("user-service", ServerWithRegistry(r))
+	require.NoError(t, err)
+	go func() {
+		_ = server.Start("127.0.0.1:0")
+	}()
+	defer server.Close()
+
+	var addr string
+	require.Eventually(t, func() bool {
+		ins, er := r.ListServices(context.Background(), "user-service")
+		if er != nil || len(ins) != 1 {
+			return false
+		}
+		addr = ins[0].Address
+		return true
+	}, time.Second, 10*time.Millisecond)
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	hc := healthpb.NewHealthClient(cc)
+
+	testCases := []struct {
+		name       string
+		status     health.Status
+		wantStatus healthpb.HealthCheckResponse_ServingStatus
+		wantIns    int
+	}{
+		{
+			name:       "not serving",
+			status:     health.StatusNotServing,
+			wantStatus: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:       "serving again",
+			status:     health.StatusServing,
+			wantStatus: healthpb.HealthCheckResponse_SERVING,
+			wantIns:    1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server.Health().SetServingStatus("", tc.status)
+			resp, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantStatus, resp.Status)
+			ins, err := r.ListServices(context.Background(), "user-service")
+			require.NoError(t, err)
+			assert.Len(t, ins, tc.wantIns)
+		})
+	}
+}
+
+// TestServer_StartRegisterFailed 注册失败的时候 Start 返回错误，并且释放端口
+func TestServer_StartRegisterFailed(t *testing.T) {
+	r := memory.NewRegistry()
+	require.NoError(t, r.Close())
+	server, err := NewServer("user-service", ServerWithRegistry(r))
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	assert.Error(t, server.Start(addr))
+	l, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	_ = l.Close()
+}